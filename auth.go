@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	dockerHubDomain     = "docker.io"
+	dockerHubServerAddr = "https://index.docker.io/v1/"
+	credHelperNotFound  = "credentials not found in native keychain"
+	credHelperPrefix    = "docker-credential-"
+	credHelperTokenUser = "<token>"
+)
+
+// Host of the Docker Hub registry API
+const dockerHubRegistryHost = "registry-1.docker.io"
+
+type dockerConfigFile struct {
+	Auths       map[string]dockerAuthEntry `json:"auths"`
+	CredsStore  string                     `json:"credsStore"`
+	CredHelpers map[string]string          `json:"credHelpers"`
+}
+
+type dockerAuthEntry struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+type credHelperOutput struct {
+	Username string
+	Secret   string
+}
+
+func dockerConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+func loadDockerConfigFile() (*dockerConfigFile, error) {
+	path, err := dockerConfigPath()
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &dockerConfigFile{}, nil
+		}
+
+		return nil, merry.Wrap(err)
+	}
+
+	var conf dockerConfigFile
+
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	return &conf, nil
+}
+
+// registryServerAddress returns the key of the registry of the image in the
+// Docker config file.
+func registryServerAddress(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	if domain := reference.Domain(named); domain != dockerHubDomain {
+		return domain, nil
+	}
+
+	return dockerHubServerAddr, nil
+}
+
+// normalizeRegistryAddress strips the scheme and path from the address, which
+// may be included in keys of the Docker config file.
+func normalizeRegistryAddress(addr string) string {
+	if addr == dockerHubServerAddr {
+		return addr
+	}
+
+	if idx := strings.Index(addr, "://"); idx >= 0 {
+		addr = addr[idx+3:]
+	}
+
+	return strings.SplitN(addr, "/", 2)[0]
+}
+
+// GetRegistryAuth returns credentials of the registry of the image. Credentials
+// are resolved from credential helpers or the Docker config file.
+func GetRegistryAuth(image string) (*types.AuthConfig, error) {
+	serverAddr, err := registryServerAddress(image)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return getServerAuth(serverAddr)
+}
+
+// getServerAuth returns credentials of the registry by its key in the Docker
+// config file.
+func getServerAuth(serverAddr string) (*types.AuthConfig, error) {
+	conf, err := loadDockerConfigFile()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if helper := conf.CredHelpers[serverAddr]; helper != "" {
+		return getCredHelperAuth(helper, serverAddr)
+	}
+
+	if conf.CredsStore != "" {
+		return getCredHelperAuth(conf.CredsStore, serverAddr)
+	}
+
+	for key, entry := range conf.Auths {
+		if normalizeRegistryAddress(key) == serverAddr {
+			return decodeDockerAuthEntry(serverAddr, &entry)
+		}
+	}
+
+	return &types.AuthConfig{ServerAddress: serverAddr}, nil
+}
+
+func decodeDockerAuthEntry(serverAddr string, entry *dockerAuthEntry) (*types.AuthConfig, error) {
+	auth := &types.AuthConfig{
+		ServerAddress: serverAddr,
+		Username:      entry.Username,
+		Password:      entry.Password,
+		IdentityToken: entry.IdentityToken,
+	}
+
+	if entry.Auth != "" {
+		data, err := base64.StdEncoding.DecodeString(entry.Auth)
+
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+
+		parts := strings.SplitN(string(data), ":", 2)
+
+		if len(parts) != 2 {
+			return nil, merry.Errorf("invalid auth configuration for %s", serverAddr)
+		}
+
+		auth.Username = parts[0]
+		auth.Password = parts[1]
+	}
+
+	return auth, nil
+}
+
+func getCredHelperAuth(helper, serverAddr string) (*types.AuthConfig, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(credHelperPrefix+helper, "get")
+	cmd.Stdin = strings.NewReader(serverAddr)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stdout.String(), credHelperNotFound) {
+			return &types.AuthConfig{ServerAddress: serverAddr}, nil
+		}
+
+		return nil, merry.Prependf(err, "credential helper %s failed: %s", helper, strings.TrimSpace(stderr.String()))
+	}
+
+	var output credHelperOutput
+
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	auth := &types.AuthConfig{ServerAddress: serverAddr}
+
+	if output.Username == credHelperTokenUser {
+		auth.IdentityToken = output.Secret
+	} else {
+		auth.Username = output.Username
+		auth.Password = output.Secret
+	}
+
+	return auth, nil
+}
+
+// EncodeRegistryAuth encodes credentials for the X-Registry-Auth header.
+func EncodeRegistryAuth(auth *types.AuthConfig) (string, error) {
+	data, err := json.Marshal(auth)
+
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	return base64.URLEncoding.EncodeToString(data), nil
+}
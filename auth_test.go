@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRegistryAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layercake")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	os.Setenv("DOCKER_CONFIG", dir)
+	defer os.Unsetenv("DOCKER_CONFIG")
+
+	t.Run("No config file", func(t *testing.T) {
+		auth, err := GetRegistryAuth("alpine")
+		require.NoError(t, err)
+		assert.Equal(t, &types.AuthConfig{ServerAddress: dockerHubServerAddr}, auth)
+	})
+
+	conf := []byte(`{
+		"auths": {
+			"https://index.docker.io/v1/": {
+				"auth": "` + base64.StdEncoding.EncodeToString([]byte("foo:bar")) + `"
+			},
+			"https://gcr.io": {
+				"username": "baz",
+				"password": "qux"
+			},
+			"quay.io": {
+				"identitytoken": "token"
+			}
+		}
+	}`)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), conf, os.ModePerm))
+
+	tests := []struct {
+		Name     string
+		Image    string
+		Expected *types.AuthConfig
+	}{
+		{
+			Name:  "Docker Hub",
+			Image: "tommy351/layercake:latest",
+			Expected: &types.AuthConfig{
+				ServerAddress: dockerHubServerAddr,
+				Username:      "foo",
+				Password:      "bar",
+			},
+		},
+		{
+			Name:  "Username and password",
+			Image: "gcr.io/foo/bar",
+			Expected: &types.AuthConfig{
+				ServerAddress: "gcr.io",
+				Username:      "baz",
+				Password:      "qux",
+			},
+		},
+		{
+			Name:  "Identity token",
+			Image: "quay.io/foo/bar:v1",
+			Expected: &types.AuthConfig{
+				ServerAddress: "quay.io",
+				IdentityToken: "token",
+			},
+		},
+		{
+			Name:  "Not found",
+			Image: "localhost:5000/foo",
+			Expected: &types.AuthConfig{
+				ServerAddress: "localhost:5000",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			auth, err := GetRegistryAuth(test.Image)
+			require.NoError(t, err)
+			assert.Equal(t, test.Expected, auth)
+		})
+	}
+
+	t.Run("Invalid image", func(t *testing.T) {
+		_, err := GetRegistryAuth("Invalid Image")
+		assert.Error(t, err)
+	})
+}
+
+func TestEncodeRegistryAuth(t *testing.T) {
+	auth := &types.AuthConfig{
+		ServerAddress: "gcr.io",
+		Username:      "foo",
+		Password:      "bar",
+	}
+
+	encoded, err := EncodeRegistryAuth(auth)
+	require.NoError(t, err)
+
+	data, err := base64.URLEncoding.DecodeString(encoded)
+	require.NoError(t, err)
+
+	var actual types.AuthConfig
+	require.NoError(t, json.Unmarshal(data, &actual))
+	assert.Equal(t, auth, &actual)
+}
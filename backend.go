@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/ansel1/merry"
+	"github.com/docker/docker/client"
+)
+
+// Backend builds and stores images. Builds only access images through the
+// backend, so they can be tested without Docker.
+type Backend interface {
+	Builder
+
+	// Save returns the image in the format of docker save.
+	Save(ctx context.Context, image string) (io.ReadCloser, error)
+
+	// Push pushes the tag and writes the progress to out.
+	Push(ctx context.Context, tag string, out io.Writer) (*PushResult, error)
+
+	// PushManifestList pushes a manifest list of the pushed manifests to the
+	// tag.
+	PushManifestList(ctx context.Context, tag string, manifests []ManifestDescriptor) error
+}
+
+// PushResult describes the manifest of a pushed image.
+type PushResult struct {
+	Digest string
+	Size   int64
+}
+
+// dockerBackend stores images in Docker. Images are built by the builder,
+// which must load built images into Docker.
+type dockerBackend struct {
+	Builder
+	client client.APIClient
+}
+
+// NewDockerBackend returns a backend of the Docker client. Images are built
+// by the builder.
+func NewDockerBackend(c client.APIClient, builder Builder) Backend {
+	return &dockerBackend{
+		Builder: builder,
+		client:  c,
+	}
+}
+
+func (d *dockerBackend) Save(ctx context.Context, image string) (io.ReadCloser, error) {
+	reader, err := d.client.ImageSave(ctx, []string{image})
+	return reader, merry.Wrap(err)
+}
+
+func (d *dockerBackend) Push(ctx context.Context, tag string, out io.Writer) (*PushResult, error) {
+	return PushImage(ctx, d.client, tag, out)
+}
+
+// PushManifestList pushes the manifest list to the registry directly, because
+// Docker doesn't store manifest lists. The registry config of Docker is used.
+func (d *dockerBackend) PushManifestList(ctx context.Context, tag string, manifests []ManifestDescriptor) error {
+	info, err := d.client.Info(ctx)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	return PushManifestList(ctx, newDaemonRegistryConfig(info.RegistryConfig), tag, manifests)
+}
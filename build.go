@@ -2,7 +2,6 @@ package main
 
 import (
 	"archive/tar"
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -12,43 +11,68 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/ansel1/merry"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/builder/dockerignore"
-	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/archive"
+	"github.com/sirupsen/logrus"
 )
 
 type BuildOptions struct {
-	BuildArgs    []FlagMap `long:"build-arg" description:"Set build-time variables"`
-	BuildKit     bool      `long:"build-kit" description:"Enable BuildKit (requires Docker 18.06+)" env:"DOCKER_BUILDKIT"`
-	CgroupParent string    `long:"cgroup-parent" description:"Optional parent cgroup for the container"`
-	CPUPeriod    int64     `long:"cpu-period" description:"Limit the CPU CFS (Completely Fair Scheduler) period"`
-	CPUQuota     int64     `long:"cpu-quota" description:"Limit the CPU CFS (Completely Fair Scheduler) quota"`
-	CPUSetCPUs   string    `long:"cpuset-cpus" description:"CPUs in which to allow execution (0-3, 0,1)"`
-	CPUSetMems   string    `long:"cpuset-mems" description:"MEMs in which to allow execution (0-3, 0,1)"`
-	CPUShares    int64     `long:"cpu-shares" description:"CPU shares (relative weight)"`
-	DryRun       bool      `long:"dry-run" description:"Print Dockerfile only"`
-	ForceRemove  bool      `long:"force-rm" description:"Always remove intermediate containers"`
-	Isolation    string    `long:"isolation" description:"Container isolation technology"`
-	Memory       int64     `long:"memory" description:"Memory limit"`
-	MemorySwap   int64     `long:"memory-swap" description:"Swap limit equal to memory plus swap: '-1' to enable unlimited swap"`
-	Network      string    `long:"network" description:" Set the networking mode for the RUN instructions during build" default:"default"`
-	NoCache      bool      `long:"no-cache" description:"Do not use cache when building the image"`
-	SecurityOpt  []string  `long:"security-opt" description:"Security options"`
+	BuildArgs      []FlagMap `long:"build-arg" description:"Set build-time variables"`
+	BuildKit       bool      `long:"build-kit" description:"Enable BuildKit (requires Docker 18.06+)" env:"DOCKER_BUILDKIT"`
+	CacheDir       string    `long:"cache-dir" description:"Path to the layer cache directory (default: .layercake/cache)" value-name:"PATH"`
+	CgroupParent   string    `long:"cgroup-parent" description:"Optional parent cgroup for the container"`
+	CPUPeriod      int64     `long:"cpu-period" description:"Limit the CPU CFS (Completely Fair Scheduler) period"`
+	CPUQuota       int64     `long:"cpu-quota" description:"Limit the CPU CFS (Completely Fair Scheduler) quota"`
+	CPUSetCPUs     string    `long:"cpuset-cpus" description:"CPUs in which to allow execution (0-3, 0,1)"`
+	CPUSetMems     string    `long:"cpuset-mems" description:"MEMs in which to allow execution (0-3, 0,1)"`
+	CPUShares      int64     `long:"cpu-shares" description:"CPU shares (relative weight)"`
+	Builder        string    `long:"builder" description:"Backend to build images" choice:"docker" choice:"buildkit" default:"docker"`
+	BuildKitAddr   string    `long:"buildkit-addr" description:"Address of the BuildKit daemon" env:"BUILDKIT_HOST" default:"unix:///run/buildkit/buildkitd.sock" value-name:"ADDR"`
+	BuildKitOutput string    `long:"buildkit-output" description:"Also push images built by BuildKit to registries or write them to OCI tarballs" choice:"docker" choice:"registry" choice:"oci" default:"docker"`
+	DryRun         bool      `long:"dry-run" description:"Print the build plan without building images"`
+	ForceRemove    bool      `long:"force-rm" description:"Always remove intermediate containers"`
+	Isolation      string    `long:"isolation" description:"Container isolation technology"`
+	Memory         int64     `long:"memory" description:"Memory limit"`
+	MemorySwap     int64     `long:"memory-swap" description:"Swap limit equal to memory plus swap: '-1' to enable unlimited swap"`
+	Network        string    `long:"network" description:" Set the networking mode for the RUN instructions during build" default:"default"`
+	NoCache        bool      `long:"no-cache" description:"Do not use cache when building the image"`
+	NoLayerCache   bool      `long:"no-layer-cache" description:"Do not reuse exported layers from previous builds"`
+	Parallel       int       `long:"parallel" description:"Number of builds to run in parallel" default:"1" value-name:"N"`
+	Platforms      []string  `long:"platform" description:"Build images for the platforms instead of platforms of builds" value-name:"PLATFORM"`
+	Push           bool      `long:"push" description:"Push tags of builds after all builds are done"`
+	Report         string    `long:"report" description:"Write a build report in JSON to the file" value-name:"PATH"`
+	Secrets        []string  `long:"secret" description:"Secret file exposed to builds with BuildKit" value-name:"id=ID,src=PATH"`
+	SecurityOpt    []string  `long:"security-opt" description:"Security options"`
+	Since          string    `long:"since" description:"Only build images affected by files changed since the git ref" value-name:"REF"`
+	SSH            []string  `long:"ssh" description:"SSH agent socket or keys exposed to builds with BuildKit" value-name:"default|ID[=SOCKET|KEY[,KEY]]"`
 
 	ctx             context.Context
-	client          client.ImageAPIClient
+	backend         Backend
 	config          *Config
 	basePath        string
 	excludePatterns []string
 	onlyBuilds      StringSet
+	targets         StringSet
 	tempDir         string
 	baseTarPath     string
-	layerHeaders    map[string]*tar.Header
+	cache           *LayerCache
+	layerPaths      map[buildTarget]map[string]string
+	secrets         map[string]string
+	ssh             []SSHSource
+	layerLock       sync.RWMutex
+	outputLock      sync.Mutex
+	report          *BuildReport
+}
+
+// buildTarget is a build for a platform.
+type buildTarget struct {
+	Name     string
+	Platform string
 }
 
 type imageManifest struct {
@@ -57,6 +81,19 @@ type imageManifest struct {
 	Layers   []string
 }
 
+// imageConfig is the platform and the history in the config of an image.
+type imageConfig struct {
+	OS           string         `json:"os"`
+	Architecture string         `json:"architecture"`
+	Variant      string         `json:"variant"`
+	History      []imageHistory `json:"history"`
+}
+
+type imageHistory struct {
+	CreatedBy  string `json:"created_by"`
+	EmptyLayer bool   `json:"empty_layer"`
+}
+
 func init() {
 	var buildOptions BuildOptions
 
@@ -70,7 +107,8 @@ func init() {
 func (b *BuildOptions) Execute(args []string) error {
 	b.ctx = globalCtx
 	b.basePath = cwd
-	b.layerHeaders = map[string]*tar.Header{}
+	b.layerPaths = map[buildTarget]map[string]string{}
+	b.report = NewBuildReport()
 
 	if len(args) > 0 {
 		b.onlyBuilds = NewStringSet()
@@ -82,8 +120,7 @@ func (b *BuildOptions) Execute(args []string) error {
 	}
 
 	if b.DryRun {
-		b.printDockerfiles()
-		return nil
+		return RunSeries(b.initTargets, b.printPlan)
 	}
 
 	tempDir, err := ioutil.TempDir("", "layercake")
@@ -95,21 +132,33 @@ func (b *BuildOptions) Execute(args []string) error {
 	b.tempDir = tempDir
 	defer os.RemoveAll(tempDir)
 
-	return RunSeries(
-		b.initClient,
+	err = RunSeries(
+		b.initTargets,
+		b.initSecrets,
 		b.loadIgnore,
+		b.initCache,
 		b.buildBaseTar,
+		b.initBackend,
 		b.startBuild,
+		b.pushImages,
 	)
-}
 
-func (b *BuildOptions) initClient() (err error) {
-	b.client, err = NewDockerClient(b.ctx)
-	return
+	// The report is written even if builds failed
+	if b.Report != "" {
+		if reportErr := b.report.WriteFile(b.Report); reportErr != nil {
+			logger.WithField("path", b.Report).Error("Failed to write the build report")
+
+			if err == nil {
+				err = reportErr
+			}
+		}
+	}
+
+	return err
 }
 
 func (b *BuildOptions) initConfig() (err error) {
-	b.config, err = InitConfig()
+	b.config, err = initPlatformConfig(b.Platforms)
 	return
 }
 
@@ -141,6 +190,32 @@ func (b *BuildOptions) loadIgnore() error {
 	return nil
 }
 
+func (b *BuildOptions) initCache() error {
+	if b.NoLayerCache {
+		return nil
+	}
+
+	dir := b.CacheDir
+
+	if dir == "" {
+		dir = filepath.Join(layercakeBaseDir, "cache")
+	}
+
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(b.basePath, dir)
+	}
+
+	b.cache = NewLayerCache(dir)
+	logger.WithField("path", dir).Debug("Layer cache is enabled")
+
+	// Exclude the cache from the build context
+	if rel, err := filepath.Rel(b.basePath, dir); err == nil && !strings.HasPrefix(rel, "..") {
+		b.excludePatterns = append(b.excludePatterns, filepath.ToSlash(rel))
+	}
+
+	return nil
+}
+
 func (b *BuildOptions) buildBaseTar() error {
 	logger.Info("Building base context")
 
@@ -175,106 +250,72 @@ func (b *BuildOptions) buildBaseTar() error {
 	return nil
 }
 
-func (b *BuildOptions) startBuild() (err error) {
-	b.config.SortBuilds().Range(func(name string, _ int) bool {
-		if b.onlyBuilds != nil {
-			skip := true
-
-			b.onlyBuilds.Range(func(value string) bool {
-				if name == value || b.config.FindDependencies(value).Contains(name) {
-					skip = false
-					return false
-				}
-
-				return true
-			})
-
-			if skip {
-				return true
-			}
-		}
-
-		build := b.config.Build[name]
+// initSecrets parses secrets and SSH sources, which are only supported by the
+// BuildKit builder.
+func (b *BuildOptions) initSecrets() (err error) {
+	if len(b.Secrets) == 0 && len(b.SSH) == 0 {
+		return nil
+	}
 
-		if err = b.buildImage(name, &build); err != nil {
-			return false
-		}
+	if b.Builder != builderBuildKit {
+		return merry.New("--secret and --ssh are only supported by --builder buildkit")
+	}
 
-		return true
-	})
+	if b.secrets, err = parseSecretFlags(b.basePath, b.Secrets); err != nil {
+		return err
+	}
 
-	return merry.Wrap(err)
+	b.ssh, err = parseSSHFlags(b.basePath, b.SSH)
+	return
 }
 
-func (b *BuildOptions) buildImage(name string, build *BuildConfig) error {
-	log := logger.WithField("prefix", name)
-	dockerFile := []byte(build.Dockerfile())
-	log.Info("Building the image")
+// initBackend connects to Docker and initializes the builder. It's skipped if
+// the backend is already set.
+func (b *BuildOptions) initBackend() error {
+	if b.backend != nil {
+		return nil
+	}
 
-	// Build tar
-	file, err := os.Open(b.baseTarPath)
+	c, err := NewDockerClient(b.ctx)
 
 	if err != nil {
-		logger.Error("Failed to open the base tar")
-		return merry.Wrap(err)
+		return err
 	}
 
-	defer file.Close()
+	if b.Builder != builderBuildKit {
+		b.backend = NewDockerBackend(c, &dockerBuilder{
+			client:      c,
+			baseTarPath: b.baseTarPath,
+			options:     b.imageBuildOptions(),
+		})
 
-	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
-	header := &tar.Header{
-		Name:    path.Join(layercakeBaseDir, "Dockerfile"),
-		Size:    int64(len(dockerFile)),
-		ModTime: time.Now(),
-		Mode:    0600,
-	}
-
-	// Write Dockerfile to tar
-	if _, err := TarAddFile(tw, header, bytes.NewReader(dockerFile)); err != nil {
-		log.Error("Failed to write Dockerfile to tar")
-		return merry.Wrap(err)
+		return nil
 	}
 
-	// Write dependency to tar
-	b.config.FindDependencies(name).Range(func(dep string) bool {
-		var file *os.File
-		layer := b.layerHeaders[dep]
-
-		if file, err = os.Open(filepath.Join(b.tempDir, layer.Name)); err != nil {
-			err = merry.Wrap(err)
-			return false
-		}
-
-		defer file.Close()
-
-		header := *layer
-		header.Name = path.Join(layercakeBaseDir, dep+".tar")
-
-		if _, err = TarAddFile(tw, &header, file); err != nil {
-			err = merry.Wrap(err)
-			return false
-		}
-
-		return true
+	builder, err := NewBuildKitBuilder(b.ctx, b.BuildKitAddr, c, BuildKitBuilderOptions{
+		BaseTarPath: b.baseTarPath,
+		ContextDir:  filepath.Join(b.tempDir, "context"),
+		Output:      b.BuildKitOutput,
+		OCIDir:      filepath.Join(b.basePath, layercakeBaseDir, "oci"),
+		NoCache:     b.NoCache,
+		SSH:         b.ssh,
 	})
 
 	if err != nil {
-		log.Error("Failed to import layers to tar")
+		logger.WithField("address", b.BuildKitAddr).Error("Failed to initialize the BuildKit builder")
 		return merry.Wrap(err)
 	}
 
-	if err := tw.Flush(); err != nil {
-		log.Error("Failed to flush the tar")
-		return merry.Wrap(err)
-	}
+	b.backend = NewDockerBackend(c, builder)
+	return nil
+}
 
-	// Build the image
+// imageBuildOptions returns options of Docker shared by all builds.
+func (b *BuildOptions) imageBuildOptions() types.ImageBuildOptions {
 	options := types.ImageBuildOptions{
 		ForceRemove:  b.ForceRemove,
 		Remove:       true,
 		NoCache:      b.NoCache,
-		BuildArgs:    map[string]*string{},
 		CPUSetCPUs:   b.CPUSetCPUs,
 		CPUSetMems:   b.CPUSetMems,
 		CPUShares:    b.CPUShares,
@@ -286,62 +327,303 @@ func (b *BuildOptions) buildImage(name string, build *BuildConfig) error {
 		NetworkMode:  b.Network,
 		SecurityOpt:  b.SecurityOpt,
 		Isolation:    container.Isolation(b.Isolation),
-		Dockerfile:   header.Name,
-		CacheFrom:    build.CacheFrom,
-		Labels:       build.Labels,
-		Tags:         build.Tags,
 	}
 
 	if b.BuildKit {
 		options.Version = types.BuilderBuildKit
 	}
 
-	for _, arg := range b.BuildArgs {
-		options.BuildArgs[arg.Key] = arg.Value
+	return options
+}
+
+func (b *BuildOptions) startBuild() error {
+	builds, err := b.selectBuilds()
+
+	if err != nil {
+		return merry.Wrap(err)
 	}
 
-	for k, v := range build.Args {
-		v := v
-		options.BuildArgs[k] = &v
+	err = RunGraph(builds, b.config.FindDependencies, b.Parallel, func(name string) error {
+		build := b.config.Build[name]
+
+		for _, platform := range build.TargetPlatforms() {
+			tags, err := build.PlatformTags(platform)
+
+			if err != nil {
+				return err
+			}
+
+			entry := b.report.Start(name, platform, b.config.BuildDockerfile(name), tags)
+			err = b.buildImage(name, platform, &build, entry)
+			entry.Finish(err)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return merry.Wrap(err)
+}
+
+func (b *BuildOptions) pushImages() error {
+	if !b.Push {
+		return nil
+	}
+
+	names := b.config.BuildNames()
+
+	if b.targets != nil {
+		names = b.targets.SortedSlice()
+	}
+
+	return PushBuilds(b.ctx, b.backend, b.config, names, os.Stdout)
+}
+
+// initTargets resolves builds requested by the user. Targets are nil if all
+// builds are requested.
+func (b *BuildOptions) initTargets() error {
+	b.targets = b.onlyBuilds
+
+	if b.Since == "" {
+		return nil
 	}
 
-	res, err := b.client.ImageBuild(b.ctx, io.MultiReader(&buf, file), options)
+	files, err := ChangedFiles(b.basePath, b.Since)
 
 	if err != nil {
-		log.Error("Failed to build the image")
+		logger.Error("Failed to find changed files")
+		return merry.Wrap(err)
+	}
+
+	logger.WithField("count", len(files)).WithField("ref", b.Since).Debug("Changed files are found")
+
+	affected := b.config.FindAffectedBuilds(files)
+
+	// Rebuild everything if any config file is changed
+	for _, file := range files {
+		if stringSliceContains(b.config.Files(), filepath.Join(b.basePath, file)) {
+			affected.Insert(b.config.BuildNames()...)
+			break
+		}
+	}
+
+	if b.targets == nil {
+		b.targets = affected
+	} else {
+		b.targets = NewStringSet()
+
+		b.onlyBuilds.Range(func(name string) bool {
+			if affected.Contains(name) {
+				b.targets.Insert(name)
+			}
+
+			return true
+		})
+	}
+
+	if b.targets.Len() == 0 {
+		logger.WithField("ref", b.Since).Info("No builds are affected")
+	} else {
+		logger.WithField("builds", strings.Join(b.targets.SortedSlice(), ", ")).Info("Affected builds are found")
+	}
+
+	return nil
+}
+
+// selectBuilds returns sorted targets and all builds they depend on.
+func (b *BuildOptions) selectBuilds() ([]string, error) {
+	if b.targets == nil {
+		return b.config.SortBuildsWithDependencies(nil)
+	}
+
+	if b.targets.Len() == 0 {
+		return nil, nil
+	}
+
+	return b.config.SortBuildsWithDependencies(b.targets.SortedSlice())
+}
+
+func (b *BuildOptions) getLayerPath(target buildTarget, set string) string {
+	b.layerLock.RLock()
+	defer b.layerLock.RUnlock()
+
+	return b.layerPaths[target][set]
+}
+
+func (b *BuildOptions) setLayerPath(target buildTarget, set, path string) {
+	b.layerLock.Lock()
+	defer b.layerLock.Unlock()
+
+	if b.layerPaths[target] == nil {
+		b.layerPaths[target] = map[string]string{}
+	}
+
+	b.layerPaths[target][set] = path
+}
+
+// buildOutput returns the writer for the build stream. Output of parallel
+// builds is prefixed with build names and written line by line.
+func (b *BuildOptions) buildOutput(name string) io.Writer {
+	if b.Parallel > 1 {
+		return NewPrefixWriter(os.Stdout, colorPrefix.Sprintf("%s:", name)+" ", &b.outputLock)
+	}
+
+	return os.Stdout
+}
+
+func (b *BuildOptions) buildImage(name, platform string, build *BuildConfig, entry *BuildReportEntry) error {
+	target := buildTarget{Name: name, Platform: platform}
+	log := logger.WithField("prefix", name)
+	layerDir := filepath.Join(b.tempDir, name)
+
+	if platform != "" {
+		log = log.WithField("platform", platform)
+		layerDir = filepath.Join(layerDir, strings.Replace(platform, "/", "-", -1))
+	}
+
+	log.Info("Building the image")
+
+	if err := os.MkdirAll(layerDir, os.ModePerm); err != nil {
+		log.Error("Failed to create a temporary directory")
+		return merry.Wrap(err)
+	}
+
+	// Prepare imported layers
+	imports := map[string]string{}
+
+	for _, script := range build.Scripts {
+		if script.Import == "" {
+			continue
+		}
+
+		file := script.ImportFile()
+
+		if _, ok := imports[file]; ok {
+			continue
+		}
+
+		src, err := b.prepareImport(layerDir, platform, &script)
+
+		if err != nil {
+			log.WithField("import", script.Import).Error("Failed to prepare imported layers")
+			return merry.Wrap(err)
+		}
+
+		if len(script.ImportOptions.Paths) > 0 {
+			defer os.Remove(src)
+		}
+
+		imports[file] = src
+	}
+
+	secrets, err := buildSecrets(name, build, b.secrets)
+
+	if err != nil {
+		log.Error("Failed to prepare secrets")
 		return merry.Wrap(err)
 	}
 
-	defer res.Body.Close()
+	sets := b.config.RequiredLayerSets(name)
+	out := b.buildOutput(name)
+	imgID, err := b.backend.Build(b.ctx, &BuildRequest{
+		Name:       name,
+		Dockerfile: []byte(entry.Dockerfile),
+		Platform:   platform,
+		Imports:    imports,
+		Args:       resolveBuildArgs(b.BuildArgs, *build),
+		Labels:     build.Labels,
+		Tags:       entry.Tags,
+		CacheFrom:  build.CacheFrom,
+		Secrets:    secrets,
+		Dir:        layerDir,
+		Output:     out,
+	})
 
-	imgID, err := DisplayBuildStream(b.ctx, res.Body, os.Stdout, options.Version)
+	if w, ok := out.(*PrefixWriter); ok {
+		if flushErr := w.Flush(); err == nil {
+			err = flushErr
+		}
+	}
 
 	if err != nil {
-		log.Error("Failed to display response")
+		log.Error("Failed to build the image")
 		return merry.Wrap(err)
 	}
 
 	log.WithField("id", imgID).Info("Image is built")
+	entry.ImageID = imgID
 
-	if len(b.config.FindDependants(name)) == 0 {
+	if sets.Len() == 0 {
 		return nil
 	}
 
-	log.Info("Exporting the layer")
+	missing := NewStringSet()
 
-	// Save the image
-	reader, err := b.client.ImageSave(b.ctx, []string{imgID})
+	for _, set := range sets.SortedSlice() {
+		if b.cache != nil {
+			if path, ok := b.cache.Get(imgID, set); ok {
+				log.WithField("path", path).WithField("layers", set).Info("Layers are loaded from cache")
+				b.setLayerPath(target, set, path)
+
+				if err := entry.AddLayer(set, path, true); err != nil {
+					return merry.Wrap(err)
+				}
+
+				continue
+			}
+		}
+
+		missing.Insert(set)
+	}
+
+	if missing.Len() == 0 {
+		return nil
+	}
+
+	log.Info("Exporting layers")
+
+	layerPaths, err := b.exportLayers(log, layerDir, imgID, platform, build, missing)
 
 	if err != nil {
-		log.Error("Failed to save the image")
+		log.Error("Failed to export layers")
 		return merry.Wrap(err)
 	}
 
+	for set, path := range layerPaths {
+		if b.cache != nil {
+			if path, err = b.cacheLayer(imgID, set, path); err != nil {
+				log.Error("Failed to write layers to cache")
+				return merry.Wrap(err)
+			}
+		}
+
+		b.setLayerPath(target, set, path)
+
+		if err := entry.AddLayer(set, path, false); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// exportLayers saves the image and writes the layer sets of the image to dir.
+// It returns paths of the layer sets.
+func (b *BuildOptions) exportLayers(log *logrus.Entry, dir, imgID, platform string, build *BuildConfig, sets StringSet) (map[string]string, error) {
+	reader, err := b.backend.Save(b.ctx, imgID)
+
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
 	defer reader.Close()
 
 	var manifests []imageManifest
+	configs := map[string][]byte{}
 	tr := tar.NewReader(reader)
-	tarHeaders := map[string]*tar.Header{}
 
 	for {
 		header, err := tr.Next()
@@ -350,33 +632,219 @@ func (b *BuildOptions) buildImage(name string, build *BuildConfig) error {
 			break
 		}
 
-		tarHeaders[header.Name] = header
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
 
 		if header.Name == "manifest.json" {
 			if manifests, err = b.decodeManifest(tr); err != nil {
-				log.Error("Failed to parse the manifest")
-				return merry.Wrap(err)
+				return nil, merry.Wrap(err)
 			}
 		} else if strings.HasSuffix(header.Name, "/layer.tar") {
-			if err := b.saveLayer(header, tr); err != nil {
-				log.Error("Failed to save the layer")
-				return merry.Wrap(err)
+			if err := b.saveLayer(dir, header, tr); err != nil {
+				return nil, merry.Wrap(err)
+			}
+		} else if strings.HasSuffix(header.Name, ".json") {
+			if configs[header.Name], err = ioutil.ReadAll(tr); err != nil {
+				return nil, merry.Wrap(err)
 			}
 		}
 	}
 
-	layers := manifests[0].Layers
+	manifest, config, err := selectImageManifest(manifests, configs, imgID, platform)
 
-	for i, layer := range layers {
-		if i == len(layers)-1 {
-			b.layerHeaders[name] = tarHeaders[layer]
-		} else if err := os.Remove(filepath.Join(b.tempDir, layer)); err != nil {
-			log.Error("Failed to remove unused layers")
-			return merry.Wrap(err)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []string
+
+	for _, layer := range manifest.Layers {
+		layers = append(layers, filepath.Join(dir, layer))
+	}
+
+	if len(layers) == 0 {
+		return nil, merry.New("the image does not contain any layers")
+	}
+
+	result := map[string]string{}
+
+	for _, set := range sets.SortedSlice() {
+		dst := filepath.Join(dir, set+".tar")
+
+		switch set {
+		case LayerSetLast:
+			whiteouts, err := stripWhiteoutsFile(layers[len(layers)-1], dst)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, wh := range whiteouts {
+				log.WithField("path", wh.Path).WithField("opaque", wh.Opaque).Debug("Whiteout is removed from the layer")
+			}
+
+		case LayerSetSinceBase:
+			count, err := countBaseLayers(config)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if count > len(layers) {
+				return nil, merry.Errorf("the image contains less layers than the base image %s", build.From)
+			}
+
+			// Whiteouts of files in the base image are discarded
+			if err := mergeLayersFile(layers[count:], dst); err != nil {
+				return nil, err
+			}
+
+		case LayerSetAll:
+			if err := mergeLayersFile(layers, dst); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, merry.Errorf("unknown layer set %q", set)
 		}
+
+		result[set] = dst
 	}
 
-	return nil
+	for _, layer := range layers {
+		if err := os.Remove(layer); err != nil {
+			return nil, merry.Wrap(err)
+		}
+	}
+
+	return result, nil
+}
+
+// countBaseLayers returns the number of layers of the base image in the built
+// image, which are layers before the last base layers label in the history.
+// Labels of base images are inherited, so only the last one is added by the
+// build.
+func countBaseLayers(config *imageConfig) (int, error) {
+	if config == nil {
+		return 0, merry.New("unable to find the config of the image")
+	}
+
+	marker := "LABEL " + baseLayersLabel + "="
+	result, count := -1, 0
+
+	for _, history := range config.History {
+		if strings.Contains(history.CreatedBy, marker) {
+			result = count
+		}
+
+		if !history.EmptyLayer {
+			count++
+		}
+	}
+
+	if result < 0 {
+		return 0, merry.New("unable to find layers of the base image in the history of the image")
+	}
+
+	return result, nil
+}
+
+// selectImageManifest returns the manifest and the config of the image in the
+// output of docker save. The manifest is found by the image ID in its config
+// file name, and the platform in the config must match the platform of the
+// build. The config is nil if it's not found.
+func selectImageManifest(manifests []imageManifest, configs map[string][]byte, imgID, platform string) (*imageManifest, *imageConfig, error) {
+	if len(manifests) == 0 {
+		return nil, nil, merry.New("unable to find the manifest of the image")
+	}
+
+	var manifest *imageManifest
+	id := strings.TrimPrefix(imgID, "sha256:")
+
+	for i, m := range manifests {
+		if name := path.Base(m.Config); name == id || name == id+".json" {
+			manifest = &manifests[i]
+			break
+		}
+	}
+
+	if manifest == nil {
+		if len(manifests) > 1 {
+			return nil, nil, merry.Errorf("unable to find the manifest of image %s", imgID)
+		}
+
+		manifest = &manifests[0]
+	}
+
+	data, ok := configs[manifest.Config]
+
+	if !ok {
+		return manifest, nil, nil
+	}
+
+	var config imageConfig
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, merry.Wrap(err)
+	}
+
+	if platform == "" {
+		return manifest, &config, nil
+	}
+
+	expected, err := ParsePlatform(platform)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	actual := Platform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}
+
+	if !actual.Matches(expected) {
+		return nil, nil, merry.Errorf("image %s is built for platform %s instead of %s", imgID, actual, platform)
+	}
+
+	return manifest, &config, nil
+}
+
+// prepareImport returns the path of the imported layer of the script. Layers
+// are filtered into dir if paths are specified.
+func (b *BuildOptions) prepareImport(dir, platform string, script *BuildScript) (string, error) {
+	importPlatform, err := b.config.ImportPlatform(script.Import, platform)
+
+	if err != nil {
+		return "", err
+	}
+
+	target := buildTarget{Name: script.Import, Platform: importPlatform}
+	src := b.getLayerPath(target, script.ImportOptions.LayerSet())
+
+	if len(script.ImportOptions.Paths) == 0 {
+		return src, nil
+	}
+
+	filtered := filepath.Join(dir, script.ImportFile())
+
+	if err := filterLayerFile(src, filtered, script.ImportOptions.MapPath); err != nil {
+		return "", err
+	}
+
+	return filtered, nil
+}
+
+// cacheLayer moves the layer set to the layer cache and returns the new path.
+func (b *BuildOptions) cacheLayer(imgID, set, path string) (string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	defer os.Remove(path)
+	defer file.Close()
+
+	return b.cache.Put(imgID, set, file)
 }
 
 func (b *BuildOptions) decodeManifest(r io.Reader) (manifests []imageManifest, err error) {
@@ -384,8 +852,8 @@ func (b *BuildOptions) decodeManifest(r io.Reader) (manifests []imageManifest, e
 	return
 }
 
-func (b *BuildOptions) saveLayer(header *tar.Header, r io.Reader) error {
-	path := filepath.Join(b.tempDir, header.Name)
+func (b *BuildOptions) saveLayer(dir string, header *tar.Header, r io.Reader) error {
+	path := filepath.Join(dir, header.Name)
 
 	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
 		return merry.Wrap(err)
@@ -406,9 +874,13 @@ func (b *BuildOptions) saveLayer(header *tar.Header, r io.Reader) error {
 	return nil
 }
 
-func (b *BuildOptions) printDockerfiles() {
-	for name, build := range b.config.Build {
-		logger.WithField("prefix", name).Info("Dockerfile")
-		fmt.Println(build.Dockerfile())
+func (b *BuildOptions) printPlan() error {
+	builds, err := b.selectBuilds()
+
+	if err != nil {
+		return merry.Wrap(err)
 	}
+
+	fmt.Print(NewBuildPlan(b.config, builds, b.BuildArgs))
+	return nil
 }
@@ -40,6 +40,28 @@ func displayBuildStreamV1(in io.Reader, out io.Writer) (string, error) {
 	return id, merry.Wrap(err)
 }
 
+// DisplayPushStream renders progress of pushing an image. It returns the
+// manifest of the pushed image, or an error if the push failed.
+func DisplayPushStream(in io.Reader, out io.Writer) (*PushResult, error) {
+	var result PushResult
+	fd, isTerm := term.GetFdInfo(out)
+
+	err := jsonmessage.DisplayJSONMessagesStream(in, out, fd, isTerm, func(msg jsonmessage.JSONMessage) {
+		var aux types.PushResult
+
+		if err := json.Unmarshal(*msg.Aux, &aux); err == nil && aux.Digest != "" {
+			result.Digest = aux.Digest
+			result.Size = int64(aux.Size)
+		}
+	})
+
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	return &result, nil
+}
+
 func displayBuildStreamBuildKit(ctx context.Context, in io.Reader, out io.Writer) (string, error) {
 	var id string
 	statusCh := make(chan *client.SolveStatus)
@@ -47,15 +69,7 @@ func displayBuildStreamBuildKit(ctx context.Context, in io.Reader, out io.Writer
 	eg, ctx := errgroup.WithContext(ctx)
 
 	eg.Go(func() error {
-		var c console.Console
-
-		if file, ok := out.(*os.File); ok {
-			if cons, err := console.ConsoleFromFile(file); err == nil {
-				c = cons
-			}
-		}
-
-		return progressui.DisplaySolveStatus(ctx, "", c, out, statusCh)
+		return displaySolveStatus(ctx, out, statusCh)
 	})
 
 	eg.Go(func() error {
@@ -91,6 +105,20 @@ func displayBuildStreamBuildKit(ctx context.Context, in io.Reader, out io.Writer
 	return id, nil
 }
 
+// displaySolveStatus renders progress of BuildKit until the channel is closed.
+// The progress is rendered interactively if the output is a console.
+func displaySolveStatus(ctx context.Context, out io.Writer, ch chan *client.SolveStatus) error {
+	var c console.Console
+
+	if file, ok := out.(*os.File); ok {
+		if cons, err := console.ConsoleFromFile(file); err == nil {
+			c = cons
+		}
+	}
+
+	return progressui.DisplaySolveStatus(ctx, "", c, out, ch)
+}
+
 func parseBuildAuxID(msg *jsonmessage.JSONMessage) (string, error) {
 	var aux types.BuildResult
 
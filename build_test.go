@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBuildOptions(t *testing.T, config *Config, backend Backend) *BuildOptions {
+	dir, err := ioutil.TempDir("", "layercake")
+	require.NoError(t, err)
+
+	return &BuildOptions{
+		Parallel:     1,
+		NoLayerCache: true,
+		ctx:          context.Background(),
+		backend:      backend,
+		config:       config,
+		basePath:     dir,
+		tempDir:      dir,
+		layerPaths:   map[buildTarget]map[string]string{},
+		report:       NewBuildReport(),
+	}
+}
+
+func newTestBuildBackend(t *testing.T) *fakeBackend {
+	backend := newFakeBackend()
+	require.NoError(t, backend.addImage("golang", []tarFile{
+		{Name: "usr/local/go/bin/go", Data: []byte("go")},
+	}))
+	require.NoError(t, backend.addImage("alpine", []tarFile{
+		{Name: "bin/sh", Data: []byte("sh")},
+	}))
+
+	backend.Files = func(build *fakeBuild) []tarFile {
+		if build.Name == "builder" {
+			return []tarFile{
+				{Name: "app/main", Data: []byte("main")},
+				{Name: "app/README", Data: []byte("readme")},
+			}
+		}
+
+		return []tarFile{{Name: build.Name, Data: []byte(build.Name)}}
+	}
+
+	return backend
+}
+
+func tarFileNames(files []tarFile) []string {
+	var names []string
+
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+
+	return names
+}
+
+func TestBuildOptions_startBuild(t *testing.T) {
+	filtered := BuildScript{
+		Import: "builder",
+		ImportOptions: ImportOptions{
+			Paths: []string{"/app/main"},
+			To:    "/usr/bin",
+		},
+	}
+	sinceBase := BuildScript{
+		Import:        "builder",
+		ImportOptions: ImportOptions{Layers: LayerSetSinceBase},
+	}
+	all := BuildScript{
+		Import:        "builder",
+		ImportOptions: ImportOptions{Layers: LayerSetAll},
+	}
+	config := &Config{
+		Build: map[string]BuildConfig{
+			"builder": {
+				From: "golang",
+				Scripts: []BuildScript{
+					{Raw: "RUN go build"},
+				},
+			},
+			"app": {
+				From: "alpine",
+				Args: map[string]string{"foo": "bar"},
+				Tags: []string{"app:latest"},
+				Scripts: []BuildScript{
+					{Import: "builder"},
+					filtered,
+				},
+			},
+			"since-base": {
+				From:    "alpine",
+				Scripts: []BuildScript{sinceBase},
+			},
+			"all": {
+				From:    "alpine",
+				Scripts: []BuildScript{all},
+			},
+		},
+	}
+
+	t.Run("Import layers", func(t *testing.T) {
+		backend := newTestBuildBackend(t)
+		b := newTestBuildOptions(t, config, backend)
+		defer os.RemoveAll(b.tempDir)
+
+		require.NoError(t, b.startBuild())
+
+		builds := backend.Builds()
+		require.Len(t, builds, 4)
+		assert.Equal(t, "builder", builds[0].Name)
+		assert.Empty(t, builds[0].Imports)
+
+		imports := map[string]map[string][]tarFile{}
+
+		for _, build := range builds {
+			imports[build.Name] = build.Imports
+		}
+
+		// The base layers label is only added to builds whose since-base layers
+		// are imported
+		assert.Equal(t, "FROM golang\nLABEL layercake.base-image=golang\nRUN go build", builds[0].Dockerfile)
+
+		for _, build := range builds[1:] {
+			assert.Equal(t, config.Build[build.Name].Dockerfile(), build.Dockerfile)
+		}
+
+		// Last layer
+		assert.ElementsMatch(t, []string{"app/main", "app/README"}, tarFileNames(imports["app"]["builder.tar"]))
+
+		// Paths mapped into the destination
+		assert.Equal(t, []tarFile{
+			{Name: "main", Data: []byte("main")},
+		}, imports["app"][filtered.ImportFile()])
+
+		// Layers added on top of the base image
+		assert.ElementsMatch(t, []string{"app/main", "app/README"}, tarFileNames(imports["since-base"][sinceBase.ImportFile()]))
+
+		// The whole file system
+		assert.ElementsMatch(t, []string{"usr/local/go/bin/go", "app/main", "app/README"}, tarFileNames(imports["all"][all.ImportFile()]))
+
+		// Build arguments
+		for _, build := range builds {
+			if build.Name == "app" {
+				require.NotNil(t, build.Args["foo"])
+				assert.Equal(t, "bar", *build.Args["foo"])
+				assert.Equal(t, []string{"app:latest"}, build.Tags)
+			}
+		}
+
+		// Report
+		require.Len(t, b.report.Builds, 4)
+
+		for _, entry := range b.report.Builds {
+			assert.True(t, entry.Success, entry.Name)
+		}
+
+		builder := b.report.Builds[0]
+		assert.Equal(t, builds[0].ImageID, builder.ImageID)
+
+		var sets []string
+
+		for _, layer := range builder.Layers {
+			assert.False(t, layer.Cached)
+			sets = append(sets, layer.Set)
+		}
+
+		assert.ElementsMatch(t, []string{LayerSetAll, LayerSetLast, LayerSetSinceBase}, sets)
+
+		// Only images imported by other builds are saved
+		assert.Equal(t, []string{builds[0].ImageID}, backend.Saved())
+	})
+
+	t.Run("Base tag changed during the build", func(t *testing.T) {
+		backend := newTestBuildBackend(t)
+		files := backend.Files
+		backend.Files = func(build *fakeBuild) []tarFile {
+			// Another build pulls the base image of another platform
+			if build.Name == "builder" {
+				backend.images["golang"] = &fakeImage{
+					Layers:  [][]byte{{}, {}, {}},
+					History: []imageHistory{{}, {}, {}},
+				}
+			}
+
+			return files(build)
+		}
+
+		b := newTestBuildOptions(t, config, backend)
+		defer os.RemoveAll(b.tempDir)
+
+		require.NoError(t, b.startBuild())
+
+		for _, build := range backend.Builds() {
+			if build.Name == "since-base" {
+				assert.ElementsMatch(t, []string{"app/main", "app/README"}, tarFileNames(build.Imports[sinceBase.ImportFile()]))
+			}
+		}
+	})
+
+	t.Run("Targets", func(t *testing.T) {
+		backend := newTestBuildBackend(t)
+		b := newTestBuildOptions(t, config, backend)
+		defer os.RemoveAll(b.tempDir)
+
+		b.targets = NewStringSet()
+		b.targets.Insert("app")
+		require.NoError(t, b.startBuild())
+
+		var names []string
+
+		for _, build := range backend.Builds() {
+			names = append(names, build.Name)
+		}
+
+		assert.Equal(t, []string{"builder", "app"}, names)
+	})
+
+	t.Run("Build failed", func(t *testing.T) {
+		backend := newTestBuildBackend(t)
+		backend.Errors["builder"] = errors.New("build failed")
+		b := newTestBuildOptions(t, config, backend)
+		defer os.RemoveAll(b.tempDir)
+
+		err := b.startBuild()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "build failed")
+
+		// Builds depending on the failed build are not started
+		builds := backend.Builds()
+		require.Len(t, builds, 1)
+		assert.Equal(t, "builder", builds[0].Name)
+
+		require.Len(t, b.report.Builds, 1)
+		assert.False(t, b.report.Builds[0].Success)
+		assert.Equal(t, "build failed", b.report.Builds[0].Error)
+	})
+
+	t.Run("Base image not found", func(t *testing.T) {
+		backend := newFakeBackend()
+		b := newTestBuildOptions(t, config, backend)
+		defer os.RemoveAll(b.tempDir)
+
+		assert.Error(t, b.startBuild())
+	})
+
+	t.Run("Layer cache", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "layercake")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		cache := NewLayerCache(filepath.Join(dir, "cache"))
+		backend := newTestBuildBackend(t)
+
+		for i := 0; i < 2; i++ {
+			b := newTestBuildOptions(t, config, backend)
+			defer os.RemoveAll(b.tempDir)
+
+			b.NoLayerCache = false
+			b.cache = cache
+			require.NoError(t, b.startBuild())
+
+			for _, layer := range b.report.Builds[0].Layers {
+				assert.Equal(t, i > 0, layer.Cached)
+			}
+		}
+
+		// Layers are only exported once
+		assert.Len(t, backend.Saved(), 1)
+	})
+
+	t.Run("Secrets", func(t *testing.T) {
+		config := &Config{
+			Build: map[string]BuildConfig{
+				"foo": {
+					From:    "alpine",
+					Secrets: []string{"npmrc"},
+				},
+				"bar": {
+					From: "alpine",
+				},
+			},
+		}
+
+		backend := newTestBuildBackend(t)
+		b := newTestBuildOptions(t, config, backend)
+		defer os.RemoveAll(b.tempDir)
+
+		b.secrets = map[string]string{
+			"npmrc": "/home/foo/.npmrc",
+			"netrc": "/home/foo/.netrc",
+		}
+		require.NoError(t, b.startBuild())
+
+		// Only declared secrets are exposed
+		for _, build := range backend.Builds() {
+			if build.Name == "foo" {
+				assert.Equal(t, map[string]string{"npmrc": "/home/foo/.npmrc"}, build.Secrets)
+			} else {
+				assert.Empty(t, build.Secrets)
+			}
+		}
+
+		b = newTestBuildOptions(t, config, backend)
+		defer os.RemoveAll(b.tempDir)
+
+		err := b.startBuild()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `build "foo" requires secret "npmrc"`)
+	})
+
+	t.Run("Platforms", func(t *testing.T) {
+		platforms := []string{"linux/amd64", "linux/arm64"}
+		config := &Config{
+			Build: map[string]BuildConfig{
+				"builder": {
+					From:      "golang",
+					Platforms: platforms,
+					Scripts: []BuildScript{
+						{Raw: "RUN go build"},
+					},
+				},
+				"app": {
+					From:      "alpine",
+					Platforms: platforms,
+					Tags:      []string{"app:1.0"},
+					Scripts: []BuildScript{
+						{Import: "builder"},
+					},
+				},
+			},
+		}
+
+		backend := newTestBuildBackend(t)
+		backend.Files = func(build *fakeBuild) []tarFile {
+			return []tarFile{{Name: build.Name, Data: []byte(build.Platform)}}
+		}
+
+		b := newTestBuildOptions(t, config, backend)
+		defer os.RemoveAll(b.tempDir)
+
+		require.NoError(t, b.startBuild())
+
+		builds := backend.Builds()
+		require.Len(t, builds, 4)
+
+		var apps []*fakeBuild
+
+		for _, build := range builds {
+			if build.Name == "app" {
+				apps = append(apps, build)
+			}
+		}
+
+		require.Len(t, apps, 2)
+
+		for _, build := range apps {
+			// Layers are imported from the build of the same platform
+			assert.Equal(t, []tarFile{
+				{Name: "builder", Data: []byte(build.Platform)},
+			}, build.Imports["builder.tar"])
+
+			tag, err := platformTag("app:1.0", build.Platform)
+			require.NoError(t, err)
+			assert.Equal(t, []string{tag}, build.Tags)
+		}
+
+		assert.ElementsMatch(t, platforms, []string{apps[0].Platform, apps[1].Platform})
+
+		// Report
+		require.Len(t, b.report.Builds, 4)
+
+		for _, entry := range b.report.Builds {
+			assert.True(t, entry.Success, entry.Name)
+			assert.Contains(t, platforms, entry.Platform)
+		}
+	})
+}
+
+func TestBuildOptions_pushImages(t *testing.T) {
+	config := &Config{
+		Build: map[string]BuildConfig{
+			"foo": {
+				From: "alpine",
+				Tags: []string{"foo:latest", "foo:1.0"},
+			},
+			"bar": {
+				From: "alpine",
+			},
+		},
+	}
+
+	backend := newTestBuildBackend(t)
+	b := newTestBuildOptions(t, config, backend)
+	defer os.RemoveAll(b.tempDir)
+
+	b.Push = true
+	require.NoError(t, b.startBuild())
+	require.NoError(t, b.pushImages())
+	assert.Equal(t, []string{"foo:latest", "foo:1.0"}, backend.Pushed())
+
+	t.Run("Platforms", func(t *testing.T) {
+		config := &Config{
+			Build: map[string]BuildConfig{
+				"foo": {
+					From:      "alpine",
+					Platforms: []string{"linux/amd64", "linux/arm/v7"},
+					Tags:      []string{"foo:1.0"},
+				},
+			},
+		}
+
+		backend := newTestBuildBackend(t)
+		b := newTestBuildOptions(t, config, backend)
+		defer os.RemoveAll(b.tempDir)
+
+		b.Push = true
+		require.NoError(t, b.startBuild())
+		require.NoError(t, b.pushImages())
+
+		// Images of platforms are pushed before the manifest list
+		assert.Equal(t, []string{"foo:1.0-linux-amd64", "foo:1.0-linux-arm-v7"}, backend.Pushed())
+
+		lists := backend.ManifestLists()
+		require.Len(t, lists["foo:1.0"], 2)
+		assert.Equal(t, Platform{OS: "linux", Architecture: "amd64"}, lists["foo:1.0"][0].Platform)
+		assert.Equal(t, Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, lists["foo:1.0"][1].Platform)
+
+		for _, m := range lists["foo:1.0"] {
+			assert.NotEmpty(t, m.Digest)
+		}
+	})
+}
+
+const testBuildConfig = `
+build:
+  builder:
+    from: alpine
+    tags:
+      - builder
+    scripts:
+      - RUN make
+  app:
+    from: alpine
+    tags:
+      - app:latest
+    args:
+      version: "1.0"
+    scripts:
+      - import: builder
+      - import:
+          from: builder
+          layers: since-base
+`
+
+// newBuildCommand returns build options with default values of flags.
+func newBuildCommand() *BuildOptions {
+	return &BuildOptions{
+		Builder:        builderDocker,
+		BuildKitOutput: BuildKitOutputDocker,
+		Network:        "default",
+		NoLayerCache:   true,
+		Parallel:       1,
+	}
+}
+
+// runBuildCommand runs the build command in the directory with the config.
+func runBuildCommand(t *testing.T, dir, config string, b *BuildOptions) error {
+	path := filepath.Join(dir, "layercake.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(config), 0644))
+
+	oldCWD, oldConfig := cwd, globalOptions.Config
+	cwd, globalOptions.Config = dir, path
+
+	defer func() {
+		cwd, globalOptions.Config = oldCWD, oldConfig
+	}()
+
+	return b.Execute(nil)
+}
+
+func TestBuildOptions_Execute(t *testing.T) {
+	setup := func(t *testing.T) (*fakeDockerServer, string, func()) {
+		dir, err := ioutil.TempDir("", "layercake")
+		require.NoError(t, err)
+
+		files := map[string]string{
+			".dockerignore": "secret.txt",
+			"hello.txt":     "hello",
+			"secret.txt":    "secret",
+		}
+
+		for name, data := range files {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+		}
+
+		server, stop := startFakeDockerServer()
+		require.NoError(t, server.Backend.addImage("alpine", []tarFile{
+			{Name: "bin/sh", Data: []byte("sh")},
+		}))
+
+		return server, dir, func() {
+			stop()
+			os.RemoveAll(dir)
+		}
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		server, dir, cleanup := setup(t)
+		defer cleanup()
+
+		b := newBuildCommand()
+		b.Report = filepath.Join(dir, "report.json")
+		require.NoError(t, runBuildCommand(t, dir, testBuildConfig, b))
+
+		// API version is negotiated
+		versions := server.Versions()
+		require.NotEmpty(t, versions)
+
+		for _, v := range versions {
+			assert.Equal(t, fakeDockerAPIVersion, v)
+		}
+
+		builds := server.Builds()
+		require.Len(t, builds, 2)
+		assert.Equal(t, []string{"builder"}, builds[0].Query["t"])
+		assert.Equal(t, "FROM alpine\nLABEL layercake.base-image=alpine\nRUN make", builds[0].Dockerfile())
+
+		app := builds[1]
+		assert.Equal(t, []string{"app:latest"}, app.Query["t"])
+		assert.JSONEq(t, `{"version":"1.0"}`, app.Query.Get("buildargs"))
+		assert.Equal(t, b.config.Build["app"].Dockerfile(), app.Dockerfile())
+
+		// The base context excludes ignored files
+		assert.Equal(t, []byte("hello"), app.File("hello.txt"))
+		assert.Nil(t, app.File("secret.txt"))
+
+		// Imported layers are added to the context
+		for _, script := range b.config.Build["app"].Scripts {
+			layer, err := readTar(bytes.NewReader(app.File(".layercake/" + script.ImportFile())))
+			require.NoError(t, err)
+			assert.Equal(t, []tarFile{{Name: "builder", Data: []byte("builder")}}, layer)
+		}
+
+		_, err := os.Stat(b.Report)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Build failed", func(t *testing.T) {
+		server, dir, cleanup := setup(t)
+		defer cleanup()
+
+		server.BuildError = "build failed"
+		err := runBuildCommand(t, dir, testBuildConfig, newBuildCommand())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "build failed")
+		assert.Len(t, server.Builds(), 1)
+	})
+
+	t.Run("Save stream broken", func(t *testing.T) {
+		server, dir, cleanup := setup(t)
+		defer cleanup()
+
+		server.SaveBroken = true
+		err := runBuildCommand(t, dir, testBuildConfig, newBuildCommand())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unexpected EOF")
+		assert.Len(t, server.Builds(), 1)
+	})
+
+	t.Run("Manifest not found", func(t *testing.T) {
+		server, dir, cleanup := setup(t)
+		defer cleanup()
+
+		server.SaveFilter = func(files []tarFile) []tarFile {
+			var result []tarFile
+
+			for _, file := range files {
+				if file.Name != "manifest.json" {
+					result = append(result, file)
+				}
+			}
+
+			return result
+		}
+
+		err := runBuildCommand(t, dir, testBuildConfig, newBuildCommand())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unable to find the manifest of the image")
+	})
+
+	t.Run("Secrets without BuildKit", func(t *testing.T) {
+		server, dir, cleanup := setup(t)
+		defer cleanup()
+
+		b := newBuildCommand()
+		b.Secrets = []string{"id=npmrc,src=.npmrc"}
+		err := runBuildCommand(t, dir, testBuildConfig, b)
+		assert.EqualError(t, err, "--secret and --ssh are only supported by --builder buildkit")
+		assert.Empty(t, server.Builds())
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+const (
+	builderDocker   = "docker"
+	builderBuildKit = "buildkit"
+)
+
+// Builder builds images. Built images must be available in Docker, so their
+// layers can be exported for other builds. Implementations must be safe for
+// concurrent use.
+type Builder interface {
+	// Build builds the image and returns its ID.
+	Build(ctx context.Context, req *BuildRequest) (string, error)
+}
+
+// BuildRequest describes a build. The build context is the base context with
+// imported layers added to the layercake directory.
+type BuildRequest struct {
+	Name       string
+	Dockerfile []byte
+
+	// Target platform, or empty for the platform of Docker
+	Platform string
+
+	// Paths of imported layers by their file names in the build context
+	Imports map[string]string
+
+	Args      map[string]*string
+	Labels    map[string]string
+	Tags      []string
+	CacheFrom []string
+
+	// Paths of secret files exposed to the build by their IDs
+	Secrets map[string]string
+
+	// Directory for temporary files of the build
+	Dir string
+
+	// Writer of the build progress
+	Output io.Writer
+}
+
+// sortedImports returns file names of imported layers in alphabetical order.
+func (r *BuildRequest) sortedImports() []string {
+	names := make([]string, 0, len(r.Imports))
+
+	for name := range r.Imports {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// dockerBuilder builds images with the ImageBuild API of Docker.
+type dockerBuilder struct {
+	client      client.ImageAPIClient
+	baseTarPath string
+
+	// Options shared by all builds
+	options types.ImageBuildOptions
+}
+
+func (d *dockerBuilder) Build(ctx context.Context, req *BuildRequest) (string, error) {
+	file, err := os.Open(d.baseTarPath)
+
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	defer file.Close()
+
+	// Imported layers can be large, so the tar is written to a file instead of
+	// memory.
+	contextFile, err := os.Create(filepath.Join(req.Dir, "context.tar"))
+
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	defer os.Remove(contextFile.Name())
+	defer contextFile.Close()
+
+	tw := tar.NewWriter(contextFile)
+	header := &tar.Header{
+		Name:    path.Join(layercakeBaseDir, "Dockerfile"),
+		Size:    int64(len(req.Dockerfile)),
+		ModTime: time.Now(),
+		Mode:    0600,
+	}
+
+	// Write Dockerfile to tar
+	if _, err := TarAddFile(tw, header, bytes.NewReader(req.Dockerfile)); err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	// Write imported layers to tar
+	for _, name := range req.sortedImports() {
+		if err := d.addImport(tw, name, req.Imports[name]); err != nil {
+			return "", merry.Wrap(err)
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	if _, err := contextFile.Seek(0, io.SeekStart); err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	options := d.options
+	options.Dockerfile = header.Name
+	options.BuildArgs = req.Args
+	options.CacheFrom = req.CacheFrom
+	options.Labels = req.Labels
+	options.Tags = req.Tags
+	options.Platform = req.Platform
+
+	res, err := d.client.ImageBuild(ctx, io.MultiReader(contextFile, file), options)
+
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	defer res.Body.Close()
+
+	return DisplayBuildStream(ctx, res.Body, req.Output, options.Version)
+}
+
+func (d *dockerBuilder) addImport(tw *tar.Writer, name, src string) error {
+	file, err := os.Open(src)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	header.Name = path.Join(layercakeBaseDir, name)
+	_, err = TarAddFile(tw, header, file)
+	return err
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/archive"
+	bkclient "github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+	"github.com/moby/buildkit/session/sshforward/sshprovider"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+)
+
+const (
+	// BuildKitOutputDocker loads images into Docker only.
+	BuildKitOutputDocker = "docker"
+
+	// BuildKitOutputRegistry pushes tags of images to registries as well.
+	BuildKitOutputRegistry = "registry"
+
+	// BuildKitOutputOCI writes images to OCI tarballs as well.
+	BuildKitOutputOCI = "oci"
+)
+
+const (
+	loadedImagePrefix   = "Loaded image: "
+	loadedImageIDPrefix = "Loaded image ID: "
+)
+
+type BuildKitBuilderOptions struct {
+	// Path of the base context tar
+	BaseTarPath string
+
+	// Directory where the base context is extracted. Contexts of builds are
+	// linked from the directory.
+	ContextDir string
+
+	// Where images are exported to in addition to Docker
+	Output string
+
+	// Directory of OCI tarballs
+	OCIDir string
+
+	NoCache bool
+
+	// SSH agent sockets or keys forwarded to all builds
+	SSH []SSHSource
+}
+
+// buildKitBuilder solves Dockerfiles with a BuildKit daemon. Images are always
+// loaded into Docker, so their layers can be exported by Docker.
+type buildKitBuilder struct {
+	client  *bkclient.Client
+	docker  client.ImageAPIClient
+	options BuildKitBuilderOptions
+}
+
+// NewBuildKitBuilder connects to the BuildKit daemon at the address and
+// extracts the base context.
+func NewBuildKitBuilder(ctx context.Context, address string, docker client.ImageAPIClient, options BuildKitBuilderOptions) (Builder, error) {
+	c, err := bkclient.New(ctx, address)
+
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	if err := untarFile(options.BaseTarPath, options.ContextDir); err != nil {
+		return nil, err
+	}
+
+	return &buildKitBuilder{
+		client:  c,
+		docker:  docker,
+		options: options,
+	}, nil
+}
+
+func (b *buildKitBuilder) Build(ctx context.Context, req *BuildRequest) (string, error) {
+	contextDir, err := b.contextDir(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer os.RemoveAll(contextDir)
+
+	dockerfileDir := filepath.Join(req.Dir, "dockerfile")
+
+	if err := os.MkdirAll(dockerfileDir, os.ModePerm); err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dockerfileDir, "Dockerfile"), req.Dockerfile, 0600); err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	localDirs := map[string]string{
+		"context":    contextDir,
+		"dockerfile": dockerfileDir,
+	}
+
+	imgID, err := b.load(ctx, req, localDirs)
+
+	if err != nil {
+		return "", err
+	}
+
+	// BuildKit only supports one exporter in a solve, so images are exported
+	// again with the cache of the first solve, which must not be ignored or the
+	// exported images may differ from the loaded one.
+	switch b.options.Output {
+	case BuildKitOutputRegistry:
+		// Images without tags can't be pushed
+		if len(req.Tags) == 0 {
+			break
+		}
+
+		err = b.solve(ctx, req, localDirs, bkclient.ExportEntry{
+			Type: bkclient.ExporterImage,
+			Attrs: map[string]string{
+				"name": strings.Join(req.Tags, ","),
+				"push": "true",
+			},
+		}, false)
+
+	case BuildKitOutputOCI:
+		err = b.exportOCI(ctx, req, localDirs)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return imgID, nil
+}
+
+// contextDir creates the context of the build in the build directory, which
+// contains files of the base context and imported layers of the build. Files of
+// the base context are linked, so contexts are cheap to create, and the context
+// isn't changed by other builds while BuildKit reads it.
+func (b *buildKitBuilder) contextDir(req *BuildRequest) (string, error) {
+	contextDir := filepath.Join(req.Dir, "context")
+	dir := filepath.Join(contextDir, layercakeBaseDir)
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	for _, name := range req.sortedImports() {
+		if err := linkOrCopyFile(req.Imports[name], filepath.Join(dir, name)); err != nil {
+			return "", err
+		}
+	}
+
+	// Imports are added first, because directories of the base context may
+	// be read-only
+	if err := linkDir(b.options.ContextDir, contextDir); err != nil {
+		return "", err
+	}
+
+	return contextDir, nil
+}
+
+// load builds the image and loads it into Docker. It returns the image ID.
+func (b *buildKitBuilder) load(ctx context.Context, req *BuildRequest, localDirs map[string]string) (string, error) {
+	var loaded string
+	pr, pw := io.Pipe()
+	eg, ctx := errgroup.WithContext(ctx)
+
+	eg.Go(func() error {
+		res, err := b.docker.ImageLoad(ctx, pr, true)
+
+		if err != nil {
+			pr.CloseWithError(err)
+			return merry.Wrap(err)
+		}
+
+		defer res.Body.Close()
+
+		loaded, err = parseLoadedImage(res.Body)
+		return err
+	})
+
+	eg.Go(func() error {
+		export := bkclient.ExportEntry{
+			Type:   bkclient.ExporterDocker,
+			Attrs:  map[string]string{},
+			Output: pw,
+		}
+
+		if len(req.Tags) > 0 {
+			export.Attrs["name"] = strings.Join(req.Tags, ",")
+		}
+
+		err := b.solve(ctx, req, localDirs, export, b.options.NoCache)
+		pw.CloseWithError(err)
+		return err
+	})
+
+	if err := eg.Wait(); err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(loaded, "sha256:") {
+		return loaded, nil
+	}
+
+	// Loaded images with tags are only reported by their tags
+	info, _, err := b.docker.ImageInspectWithRaw(ctx, loaded)
+
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	return info.ID, nil
+}
+
+func (b *buildKitBuilder) exportOCI(ctx context.Context, req *BuildRequest, localDirs map[string]string) error {
+	if err := os.MkdirAll(b.options.OCIDir, os.ModePerm); err != nil {
+		return merry.Wrap(err)
+	}
+
+	name := req.Name
+
+	if req.Platform != "" {
+		name += "-" + strings.Replace(req.Platform, "/", "-", -1)
+	}
+
+	file, err := os.Create(filepath.Join(b.options.OCIDir, name+".tar"))
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	defer file.Close()
+
+	export := bkclient.ExportEntry{
+		Type:   bkclient.ExporterOCI,
+		Attrs:  map[string]string{},
+		Output: file,
+	}
+
+	if len(req.Tags) > 0 {
+		export.Attrs["name"] = strings.Join(req.Tags, ",")
+	}
+
+	return b.solve(ctx, req, localDirs, export, false)
+}
+
+// solve builds the image and exports it. The cache is ignored if noCache is
+// true.
+func (b *buildKitBuilder) solve(ctx context.Context, req *BuildRequest, localDirs map[string]string, export bkclient.ExportEntry, noCache bool) error {
+	attachables, err := b.sessionAttachables(req)
+
+	if err != nil {
+		return err
+	}
+
+	opt := bkclient.SolveOpt{
+		Exports:       []bkclient.ExportEntry{export},
+		LocalDirs:     localDirs,
+		Frontend:      "dockerfile.v0",
+		FrontendAttrs: b.frontendAttrs(req, noCache),
+		Session:       attachables,
+	}
+
+	for _, ref := range req.CacheFrom {
+		opt.CacheImports = append(opt.CacheImports, bkclient.CacheOptionsEntry{
+			Type:  "registry",
+			Attrs: map[string]string{"ref": ref},
+		})
+	}
+
+	ch := make(chan *bkclient.SolveStatus)
+	eg, ctx := errgroup.WithContext(ctx)
+
+	eg.Go(func() error {
+		_, err := b.client.Solve(ctx, nil, opt, ch)
+		return merry.Wrap(err)
+	})
+
+	eg.Go(func() error {
+		return displaySolveStatus(ctx, req.Output, ch)
+	})
+
+	return eg.Wait()
+}
+
+// sessionAttachables returns services provided to BuildKit in the session of
+// the build. Only secrets declared in the build are exposed.
+func (b *buildKitBuilder) sessionAttachables(req *BuildRequest) ([]session.Attachable, error) {
+	result := []session.Attachable{&buildKitAuthProvider{}}
+
+	if len(req.Secrets) > 0 {
+		var sources []secretsprovider.FileSource
+
+		for id, src := range req.Secrets {
+			sources = append(sources, secretsprovider.FileSource{ID: id, FilePath: src})
+		}
+
+		store, err := secretsprovider.NewFileStore(sources)
+
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+
+		result = append(result, secretsprovider.NewSecretProvider(store))
+	}
+
+	if len(b.options.SSH) > 0 {
+		var configs []sshprovider.AgentConfig
+
+		for _, source := range b.options.SSH {
+			configs = append(configs, sshprovider.AgentConfig{ID: source.ID, Paths: source.Paths})
+		}
+
+		provider, err := sshprovider.NewSSHAgentProvider(configs)
+
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+
+		result = append(result, provider)
+	}
+
+	return result, nil
+}
+
+// frontendAttrs returns attributes of the Dockerfile frontend. Build arguments
+// without values are skipped.
+func (b *buildKitBuilder) frontendAttrs(req *BuildRequest, noCache bool) map[string]string {
+	attrs := map[string]string{
+		"filename": "Dockerfile",
+	}
+
+	if noCache {
+		attrs["no-cache"] = ""
+	}
+
+	if req.Platform != "" {
+		attrs["platform"] = req.Platform
+	}
+
+	for k, v := range req.Args {
+		if v != nil {
+			attrs["build-arg:"+k] = *v
+		}
+	}
+
+	for k, v := range req.Labels {
+		attrs["label:"+k] = v
+	}
+
+	return attrs
+}
+
+// parseLoadedImage reads the response of loading images into Docker. It
+// returns the ID of the loaded image, or its first tag if the image is tagged.
+func parseLoadedImage(r io.Reader) (string, error) {
+	var result string
+	decoder := json.NewDecoder(r)
+
+	for {
+		var msg struct {
+			Stream string `json:"stream"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"errorDetail"`
+		}
+
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", merry.Wrap(err)
+		}
+
+		if msg.Error != nil {
+			return "", merry.New(msg.Error.Message)
+		}
+
+		for _, line := range strings.Split(msg.Stream, "\n") {
+			if result != "" {
+				break
+			}
+
+			if strings.HasPrefix(line, loadedImageIDPrefix) {
+				result = strings.TrimPrefix(line, loadedImageIDPrefix)
+			} else if strings.HasPrefix(line, loadedImagePrefix) {
+				result = strings.TrimPrefix(line, loadedImagePrefix)
+			}
+		}
+	}
+
+	if result == "" {
+		return "", merry.New("unable to find the loaded image")
+	}
+
+	return result, nil
+}
+
+// buildKitAuthProvider provides credentials of registries to BuildKit from the
+// Docker config file.
+type buildKitAuthProvider struct{}
+
+func (a *buildKitAuthProvider) Register(server *grpc.Server) {
+	auth.RegisterAuthServer(server, a)
+}
+
+func (a *buildKitAuthProvider) Credentials(ctx context.Context, req *auth.CredentialsRequest) (*auth.CredentialsResponse, error) {
+	serverAddr := req.Host
+
+	if serverAddr == dockerHubRegistryHost {
+		serverAddr = dockerHubServerAddr
+	}
+
+	ac, err := getServerAuth(serverAddr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ac.IdentityToken != "" {
+		return &auth.CredentialsResponse{Secret: ac.IdentityToken}, nil
+	}
+
+	return &auth.CredentialsResponse{Username: ac.Username, Secret: ac.Password}, nil
+}
+
+func untarFile(src, dst string) error {
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return merry.Wrap(err)
+	}
+
+	file, err := os.Open(src)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	defer file.Close()
+
+	return merry.Wrap(archive.Untar(file, dst, &archive.TarOptions{NoLchown: true}))
+}
+
+// linkDir recreates the directory tree of src in dst, and links files in it.
+// Directories are writable until all files are linked, and then they get the
+// modes of directories in src.
+func linkDir(src, dst string) error {
+	modes := map[string]os.FileMode{}
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return merry.Wrap(err)
+		}
+
+		rel, err := filepath.Rel(src, path)
+
+		if err != nil {
+			return merry.Wrap(err)
+		}
+
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			modes[target] = info.Mode().Perm()
+			return merry.Wrap(os.MkdirAll(target, os.ModePerm))
+
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+
+			if err != nil {
+				return merry.Wrap(err)
+			}
+
+			return merry.Wrap(os.Symlink(link, target))
+
+		default:
+			return linkOrCopyFile(path, target)
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for dir, mode := range modes {
+		if err := os.Chmod(dir, mode); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// linkOrCopyFile creates a hard link of the file, or copies the file if they
+// are on different devices.
+func linkOrCopyFile(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	defer in.Close()
+
+	out, err := os.Create(dst)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return merry.Wrap(err)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLoadedImage(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Input    string
+		Expected string
+		Error    string
+	}{
+		{
+			Name:     "Image ID",
+			Input:    `{"stream":"Loaded image ID: sha256:abc\n"}`,
+			Expected: "sha256:abc",
+		},
+		{
+			Name:     "Tags",
+			Input:    `{"stream":"Loaded image: foo:latest\n"}` + "\n" + `{"stream":"Loaded image: bar:latest\n"}`,
+			Expected: "foo:latest",
+		},
+		{
+			Name:  "Error",
+			Input: `{"errorDetail":{"message":"invalid tar"},"error":"invalid tar"}`,
+			Error: "invalid tar",
+		},
+		{
+			Name:  "Not found",
+			Input: `{"stream":"foo\n"}`,
+			Error: "unable to find the loaded image",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			actual, err := parseLoadedImage(strings.NewReader(test.Input))
+
+			if test.Error != "" {
+				assert.EqualError(t, err, test.Error)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, test.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestBuildKitBuilder_frontendAttrs(t *testing.T) {
+	value := "bar"
+	builder := &buildKitBuilder{}
+	req := &BuildRequest{
+		Platform: "linux/arm64",
+		Args:     map[string]*string{"FOO": &value, "PROXY": nil},
+		Labels:   map[string]string{"maintainer": "foo"},
+	}
+
+	t.Run("No cache", func(t *testing.T) {
+		assert.Equal(t, map[string]string{
+			"filename":         "Dockerfile",
+			"no-cache":         "",
+			"platform":         "linux/arm64",
+			"build-arg:FOO":    "bar",
+			"label:maintainer": "foo",
+		}, builder.frontendAttrs(req, true))
+	})
+
+	t.Run("Use cache", func(t *testing.T) {
+		assert.Equal(t, map[string]string{
+			"filename":         "Dockerfile",
+			"platform":         "linux/arm64",
+			"build-arg:FOO":    "bar",
+			"label:maintainer": "foo",
+		}, builder.frontendAttrs(req, false))
+	})
+}
+
+func TestBuildKitBuilder_contextDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layercake")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "layer.tar")
+	require.NoError(t, ioutil.WriteFile(src, []byte("foo"), 0644))
+
+	baseDir := filepath.Join(dir, "base")
+	require.NoError(t, os.MkdirAll(filepath.Join(baseDir, "src"), os.ModePerm))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(baseDir, "src", "foo.txt"), []byte("foo"), 0644))
+	require.NoError(t, os.Symlink("src/foo.txt", filepath.Join(baseDir, "link")))
+
+	// Read-only directories
+	require.NoError(t, os.Chmod(filepath.Join(baseDir, "src"), 0555))
+	require.NoError(t, os.Chmod(baseDir, 0555))
+
+	defer func() {
+		for _, name := range []string{"base", "foo/context", "bar/context"} {
+			os.Chmod(filepath.Join(dir, name), 0755)
+			os.Chmod(filepath.Join(dir, name, "src"), 0755)
+		}
+	}()
+
+	builder := &buildKitBuilder{
+		options: BuildKitBuilderOptions{ContextDir: baseDir},
+	}
+
+	newRequest := func(name string) *BuildRequest {
+		return &BuildRequest{
+			Dir:     filepath.Join(dir, name),
+			Imports: map[string]string{name + ".tar": src},
+		}
+	}
+
+	foo, err := builder.contextDir(newRequest("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "foo", "context"), foo)
+
+	bar, err := builder.contextDir(newRequest("bar"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "bar", "context"), bar)
+
+	// Files of the base context
+	data, err := ioutil.ReadFile(filepath.Join(foo, "src", "foo.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(data))
+
+	link, err := os.Readlink(filepath.Join(foo, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "src/foo.txt", link)
+
+	// Modes of directories are kept
+	info, err := os.Stat(filepath.Join(foo, "src"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0555), info.Mode().Perm())
+
+	// Each context only contains imports of its build
+	data, err = ioutil.ReadFile(filepath.Join(foo, layercakeBaseDir, "foo.tar"))
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(data))
+
+	_, err = os.Stat(filepath.Join(foo, layercakeBaseDir, "bar.tar"))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(filepath.Join(bar, layercakeBaseDir, "bar.tar"))
+	assert.NoError(t, err)
+
+	// The base context is unchanged
+	_, err = os.Stat(filepath.Join(baseDir, layercakeBaseDir))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestBuildKitBuilder_sessionAttachables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layercake")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "npmrc")
+	require.NoError(t, ioutil.WriteFile(src, []byte("token"), 0600))
+
+	t.Run("Auth only", func(t *testing.T) {
+		builder := &buildKitBuilder{}
+		actual, err := builder.sessionAttachables(&BuildRequest{})
+		require.NoError(t, err)
+		assert.Len(t, actual, 1)
+	})
+
+	t.Run("Secrets", func(t *testing.T) {
+		builder := &buildKitBuilder{}
+		actual, err := builder.sessionAttachables(&BuildRequest{
+			Secrets: map[string]string{"npmrc": src},
+		})
+		require.NoError(t, err)
+		assert.Len(t, actual, 2)
+	})
+
+	t.Run("SSH key not found", func(t *testing.T) {
+		builder := &buildKitBuilder{
+			options: BuildKitBuilderOptions{
+				SSH: []SSHSource{{ID: "default", Paths: []string{filepath.Join(dir, "id_rsa")}}},
+			},
+		}
+		_, err := builder.sessionAttachables(&BuildRequest{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/ansel1/merry"
+)
+
+// nolint: gochecknoglobals
+var imageIDPattern = regexp.MustCompile(`^([a-z0-9]+):([a-f0-9]+)$`)
+
+// LayerCache stores exported layer sets on disk. Layers are keyed by image IDs
+// and layer sets, so layers can be reused as long as the image is not changed.
+type LayerCache struct {
+	dir string
+}
+
+func NewLayerCache(dir string) *LayerCache {
+	return &LayerCache{dir: dir}
+}
+
+func (c *LayerCache) path(id, set string) (string, error) {
+	match := imageIDPattern.FindStringSubmatch(id)
+
+	if match == nil {
+		return "", merry.Errorf("invalid image ID %q", id)
+	}
+
+	return filepath.Join(c.dir, match[1], match[2], set+".tar"), nil
+}
+
+// Get returns the path of the cached layer set of the image.
+func (c *LayerCache) Get(id, set string) (string, bool) {
+	path, err := c.path(id, set)
+
+	if err != nil {
+		return "", false
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+
+	return path, true
+}
+
+// Put writes the layer set of the image to the cache and returns the path of
+// the cached layer. The layer is written to a temporary file first so that
+// incomplete layers are never visible to Get.
+func (c *LayerCache) Put(id, set string, r io.Reader) (string, error) {
+	path, err := c.path(id, set)
+
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Dir(path)
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	file, err := ioutil.TempFile(dir, "layer")
+
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return "", merry.Wrap(err)
+	}
+
+	if err := file.Close(); err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	return path, nil
+}
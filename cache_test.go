@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayerCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layercake")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cache := NewLayerCache(dir)
+	id := "sha256:0123456789abcdef"
+
+	t.Run("Miss", func(t *testing.T) {
+		path, ok := cache.Get(id, LayerSetLast)
+		assert.False(t, ok)
+		assert.Empty(t, path)
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		path, err := cache.Put(id, LayerSetLast, bytes.NewReader([]byte("foo")))
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "sha256", "0123456789abcdef", "last.tar"), path)
+
+		data, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("foo"), data)
+
+		// Temporary files should be removed
+		files, err := ioutil.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		assert.Len(t, files, 1)
+	})
+
+	t.Run("Hit", func(t *testing.T) {
+		path, ok := cache.Get(id, LayerSetLast)
+		assert.True(t, ok)
+		assert.Equal(t, filepath.Join(dir, "sha256", "0123456789abcdef", "last.tar"), path)
+	})
+
+	t.Run("Other layer set", func(t *testing.T) {
+		_, ok := cache.Get(id, LayerSetAll)
+		assert.False(t, ok)
+	})
+
+	t.Run("Invalid ID", func(t *testing.T) {
+		for _, id := range []string{"", "0123456789abcdef", "sha256:../foo", "../sha256:abc"} {
+			_, err := cache.Put(id, LayerSetLast, bytes.NewReader(nil))
+			assert.Error(t, err, id)
+
+			_, ok := cache.Get(id, LayerSetLast)
+			assert.False(t, ok, id)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+// ChangedFiles returns files changed since the git ref in the directory,
+// including uncommitted and untracked files. Paths are relative to the
+// directory. Both paths of renamed files are returned.
+func ChangedFiles(dir, ref string) ([]string, error) {
+	result := NewStringSet()
+	commands := [][]string{
+		{"diff", "--name-only", "--no-renames", "--relative", ref, "--"},
+		{"ls-files", "--others", "--exclude-standard"},
+	}
+
+	for _, args := range commands {
+		output, err := runGit(dir, args...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range strings.Split(output, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				result.Insert(line)
+			}
+		}
+	}
+
+	return result.SortedSlice(), nil
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", merry.Prependf(err, "git %s failed: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// MatchInputs returns true if the file matches one of input patterns. A pattern
+// matches the file itself or any of its parent directories. Patterns are in the
+// syntax of path.Match.
+func MatchInputs(patterns []string, file string) bool {
+	file = normalizeTarName(file)
+
+	for _, pattern := range patterns {
+		pattern = normalizeTarName(pattern)
+
+		// The pattern is the root directory
+		if pattern == "" {
+			return true
+		}
+
+		for p := file; p != "."; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layercake")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeFile := func(name, content string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), os.ModePerm))
+	}
+
+	git := func(args ...string) {
+		_, err := runGit(dir, args...)
+		require.NoError(t, err)
+	}
+
+	git("init")
+	git("config", "user.name", "test")
+	git("config", "user.email", "test@example.com")
+	writeFile("a/main.go", "a")
+	writeFile("b/main.go", "b")
+	writeFile("d/util.go", "util")
+	git("add", "-A")
+	git("commit", "-m", "init")
+	git("tag", "base")
+
+	writeFile("a/main.go", "a2")
+	git("commit", "-am", "update a")
+	writeFile("b/main.go", "b2")
+	writeFile("c/main.go", "c")
+
+	t.Run("Success", func(t *testing.T) {
+		files, err := ChangedFiles(dir, "base")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a/main.go", "b/main.go", "c/main.go"}, files)
+	})
+
+	t.Run("Subdirectory", func(t *testing.T) {
+		files, err := ChangedFiles(filepath.Join(dir, "a"), "base")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"main.go"}, files)
+	})
+
+	t.Run("Invalid ref", func(t *testing.T) {
+		_, err := ChangedFiles(dir, "foo")
+		assert.Error(t, err)
+	})
+
+	t.Run("Moved files", func(t *testing.T) {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "e"), os.ModePerm))
+		git("mv", "d/util.go", "e/util.go")
+		git("commit", "-m", "move util")
+
+		files, err := ChangedFiles(dir, "base")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a/main.go", "b/main.go", "c/main.go", "d/util.go", "e/util.go"}, files)
+
+		// Builds with inputs of either side are changed
+		for _, pattern := range []string{"d/*", "e/*"} {
+			matched := false
+
+			for _, file := range files {
+				matched = matched || MatchInputs([]string{pattern}, file)
+			}
+
+			assert.True(t, matched, pattern)
+		}
+	})
+}
+
+func TestMatchInputs(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Patterns []string
+		File     string
+		Expected bool
+	}{
+		{
+			Name:     "File",
+			Patterns: []string{"main.go"},
+			File:     "main.go",
+			Expected: true,
+		},
+		{
+			Name:     "Directory",
+			Patterns: []string{"./cmd/foo/"},
+			File:     "cmd/foo/main.go",
+			Expected: true,
+		},
+		{
+			Name:     "Similar prefix",
+			Patterns: []string{"cmd/foo"},
+			File:     "cmd/foobar/main.go",
+		},
+		{
+			Name:     "Glob",
+			Patterns: []string{"foo", "*.go"},
+			File:     "main.go",
+			Expected: true,
+		},
+		{
+			Name:     "Glob directory",
+			Patterns: []string{"cmd/*"},
+			File:     "cmd/foo/main.go",
+			Expected: true,
+		},
+		{
+			Name:     "Root",
+			Patterns: []string{"."},
+			File:     "main.go",
+			Expected: true,
+		},
+		{
+			Name:     "Not matched",
+			Patterns: []string{"*.md"},
+			File:     "main.go",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, MatchInputs(test.Patterns, test.File))
+		})
+	}
+}
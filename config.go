@@ -1,19 +1,30 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/ansel1/merry"
-	"gopkg.in/yaml.v2"
+	"github.com/docker/distribution/reference"
+	"gopkg.in/yaml.v3"
 )
 
+// Dockerfile syntax supporting RUN --mount in BuildKit
+const dockerfileExperimentalSyntax = "docker/dockerfile:1.0-experimental"
+
 // nolint: gochecknoglobals
 var (
 	defaultConfigPaths = []string{"layercake.yml", "layercake.yaml"}
@@ -21,7 +32,47 @@ var (
 )
 
 type Config struct {
-	Build map[string]BuildConfig `yaml:"build"`
+	Build     map[string]BuildConfig `yaml:"build,omitempty"`
+	Templates map[string]BuildConfig `yaml:"templates,omitempty"`
+	Variables map[string]string      `yaml:"variables,omitempty"`
+	Include   []string               `yaml:"include,omitempty"`
+
+	// Absolute paths of loaded config files
+	files []string
+
+	// Positions of fields in the config file
+	positions map[string]Position
+}
+
+func (c *Config) setPosition(field string, pos Position) {
+	if c.positions == nil {
+		c.positions = map[string]Position{}
+	}
+
+	c.positions[field] = pos
+}
+
+func (c *Config) position(field string) Position {
+	if pos, ok := c.positions[field]; ok {
+		return pos
+	}
+
+	return c.positions[""]
+}
+
+// variablePosition returns the position of the variable. Variables of included
+// files are located in their files.
+func (c *Config) variablePosition(name string) Position {
+	if pos, ok := c.positions["variables."+name]; ok {
+		return pos
+	}
+
+	return c.position("variables")
+}
+
+// Files returns absolute paths of loaded config files.
+func (c *Config) Files() []string {
+	return c.files
 }
 
 func (c *Config) FindDependencies(name string) StringSet {
@@ -42,7 +93,198 @@ func (c *Config) FindDependants(name string) StringSet {
 	return result
 }
 
-func (c *Config) SortBuilds() *OrderedStringSet {
+// RequiredLayerSets returns layer sets of the build imported by other builds.
+func (c *Config) RequiredLayerSets(name string) StringSet {
+	result := NewStringSet()
+
+	c.FindDependants(name).Range(func(dep string) bool {
+		for _, script := range c.Build[dep].Scripts {
+			if script.Import == name {
+				result.Insert(script.ImportOptions.LayerSet())
+			}
+		}
+
+		return true
+	})
+
+	return result
+}
+
+// BuildDockerfile returns the Dockerfile used to build the build. If since-base
+// layers of the build are imported, the base layers label is added after FROM,
+// because the base tag may be changed by other builds, so base layers are found
+// in the history of the built image.
+func (c *Config) BuildDockerfile(name string) string {
+	build := c.Build[name]
+
+	if c.RequiredLayerSets(name).Contains(LayerSetSinceBase) {
+		label := BuildScript{Instruction: "LABEL", Value: baseLayersLabel + "=" + build.From}
+		build.Scripts = append([]BuildScript{label}, build.Scripts...)
+	}
+
+	return build.Dockerfile()
+}
+
+// ImportPlatform returns the platform of the imported build whose layers are
+// imported by a build of the platform. An empty string is the platform of
+// Docker, which is used by builds without platforms.
+func (c *Config) ImportPlatform(name, platform string) (string, error) {
+	platforms := c.Build[name].Platforms
+
+	if len(platforms) == 0 {
+		return "", nil
+	}
+
+	for _, p := range platforms {
+		if samePlatform(p, platform) {
+			return p, nil
+		}
+	}
+
+	if platform == "" && len(platforms) == 1 {
+		return platforms[0], nil
+	}
+
+	if platform == "" {
+		return "", merry.Errorf("build %q is built for multiple platforms, so builds importing it must specify platforms", name)
+	}
+
+	return "", merry.Errorf("build %q is not built for platform %s", name, platform)
+}
+
+// SetPlatforms overrides platforms of all builds.
+func (c *Config) SetPlatforms(platforms []string) {
+	for name, build := range c.Build {
+		build.Platforms = platforms
+		c.Build[name] = build
+	}
+}
+
+// FindAllDependencies returns all builds which the build depends on directly or
+// indirectly.
+func (c *Config) FindAllDependencies(name string) StringSet {
+	return c.walk(name, c.FindDependencies)
+}
+
+// FindAllDependants returns all builds which depend on the build directly or
+// indirectly.
+func (c *Config) FindAllDependants(name string) StringSet {
+	return c.walk(name, c.FindDependants)
+}
+
+func (c *Config) walk(name string, next func(name string) StringSet) StringSet {
+	result := NewStringSet()
+	queue := []string{name}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		next(current).Range(func(value string) bool {
+			if value != name && !result.Contains(value) {
+				result.Insert(value)
+				queue = append(queue, value)
+			}
+
+			return true
+		})
+	}
+
+	return result
+}
+
+// BuildNames returns names of all builds in alphabetical order.
+func (c *Config) BuildNames() []string {
+	return sortedBuildNames(c.Build)
+}
+
+func sortedBuildNames(builds map[string]BuildConfig) []string {
+	result := make([]string, 0, len(builds))
+
+	for name := range builds {
+		result = append(result, name)
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// FindCycle returns the first dependency cycle found in builds, starting and
+// ending with the same build name. It returns nil if there are no cycles.
+func (c *Config) FindCycle() []string {
+	var (
+		stack   []string
+		visit   func(name string) []string
+		visited = NewStringSet()
+		onPath  = map[string]int{}
+	)
+
+	visit = func(name string) []string {
+		if idx, ok := onPath[name]; ok {
+			return append(append([]string{}, stack[idx:]...), name)
+		}
+
+		if visited.Contains(name) {
+			return nil
+		}
+
+		visited.Insert(name)
+		onPath[name] = len(stack)
+		stack = append(stack, name)
+
+		for _, dep := range c.FindDependencies(name).SortedSlice() {
+			if _, ok := c.Build[dep]; !ok {
+				continue
+			}
+
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		delete(onPath, name)
+
+		return nil
+	}
+
+	for _, name := range c.BuildNames() {
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
+
+// FindAffectedBuilds returns builds affected by changed files and all builds
+// depending on them. Paths of files are relative to the build context. Builds
+// without inputs are always affected.
+func (c *Config) FindAffectedBuilds(files []string) StringSet {
+	result := NewStringSet()
+
+	for name, build := range c.Build {
+		if len(build.Inputs) == 0 {
+			result.Insert(name)
+			continue
+		}
+
+		for _, file := range files {
+			if MatchInputs(build.Inputs, file) {
+				result.Insert(name)
+				break
+			}
+		}
+	}
+
+	for _, name := range result.Slice() {
+		result.Insert(c.FindAllDependants(name).Slice()...)
+	}
+
+	return result
+}
+
+func (c *Config) SortBuilds() (*OrderedStringSet, error) {
 	result := NewOrderedStringSet()
 	depMap := map[string]StringSet{}
 
@@ -51,6 +293,8 @@ func (c *Config) SortBuilds() *OrderedStringSet {
 	}
 
 	for len(depMap) > 0 {
+		remaining := len(depMap)
+
 		for k, deps := range depMap {
 			for dep := range deps {
 				if result.Contains(dep) {
@@ -63,48 +307,216 @@ func (c *Config) SortBuilds() *OrderedStringSet {
 				result.Insert(k)
 			}
 		}
+
+		if len(depMap) == remaining {
+			if cycle := c.FindCycle(); cycle != nil {
+				return nil, newCycleError(cycle)
+			}
+
+			unresolved := NewStringSet()
+
+			for k := range depMap {
+				unresolved.Insert(k)
+			}
+
+			return nil, fmt.Errorf("unable to resolve dependencies of %s", strings.Join(unresolved.SortedSlice(), ", "))
+		}
 	}
 
-	return result
+	return result, nil
 }
 
-func (c *Config) Validate() (err error) {
-	for name, build := range c.Build {
-		name := name
+// SortBuildsWithDependencies returns the builds and all builds they depend on,
+// with dependencies before the builds depending on them. Builds without
+// dependencies between them are sorted by their names. All builds are
+// returned if names are empty.
+func (c *Config) SortBuildsWithDependencies(names []string) ([]string, error) {
+	if len(names) == 0 {
+		names = c.BuildNames()
+	}
+
+	for _, name := range names {
+		if _, ok := c.Build[name]; !ok {
+			return nil, merry.Errorf("build %q is not defined", name)
+		}
+	}
+
+	var result []string
+	visited := NewStringSet()
+	var visit func(name string)
+
+	visit = func(name string) {
+		if visited.Contains(name) {
+			return
+		}
+
+		visited.Insert(name)
+
+		for _, dep := range c.FindDependencies(name).SortedSlice() {
+			if _, ok := c.Build[dep]; ok {
+				visit(dep)
+			}
+		}
+
+		result = append(result, name)
+	}
+
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		visit(name)
+	}
+
+	return result, nil
+}
+
+// Validate checks builds and returns all errors found.
+func (c *Config) Validate() error {
+	errs := c.validateExtends()
+
+	for _, name := range c.BuildNames() {
+		build := c.Build[name]
 
 		if build.From == "" {
-			return fmt.Errorf("build %q must have a base image", name)
+			errs = append(errs, newConfigError(build.position(""), "build %q must have a base image", name))
 		}
 
-		build.FindImports().Range(func(key string) bool {
-			if _, ok := c.Build[key]; !ok {
-				err = fmt.Errorf("build %q contains undefined import %q", name, key)
-				return false
+		for _, platform := range build.Platforms {
+			if _, err := ParsePlatform(platform); err != nil {
+				errs = append(errs, newConfigError(build.position("platforms"), "build %q has %s", name, err))
 			}
+		}
 
-			return true
-		})
+		for _, tag := range build.Tags {
+			ref, err := reference.ParseNormalizedNamed(tag)
 
-		if err != nil {
-			return
+			if err != nil {
+				errs = append(errs, newConfigError(build.position("tags"), "build %q has invalid tag %q: %s", name, tag, err))
+			} else if _, ok := ref.(reference.Digested); ok {
+				errs = append(errs, newConfigError(build.position("tags"), "build %q has tag %q with a digest", name, tag))
+			}
+		}
+
+		for _, script := range build.Scripts {
+			for _, id := range script.MountedSecrets() {
+				if !stringSliceContains(build.Secrets, id) {
+					errs = append(errs, newConfigError(script.pos, "build %q mounts undeclared secret %q", name, id))
+				}
+			}
+
+			if script.Import == "" {
+				continue
+			}
+
+			if _, ok := c.Build[script.Import]; !ok {
+				errs = append(errs, newConfigError(script.pos, "build %q contains undefined import %q", name, script.Import))
+				continue
+			}
+
+			for _, platform := range build.TargetPlatforms() {
+				if _, err := c.ImportPlatform(script.Import, platform); err != nil {
+					errs = append(errs, newConfigError(script.pos, "build %q: %s", name, err))
+					break
+				}
+			}
 		}
 	}
 
-	return
+	if cycle := c.FindCycle(); cycle != nil {
+		errs = append(errs, newConfigError(c.Build[cycle[0]].position(""), "%s", newCycleError(cycle)))
+	}
+
+	return errs.Err()
+}
+
+func newCycleError(cycle []string) error {
+	return fmt.Errorf("dependency cycle detected: %s", strings.Join(cycle, " -> "))
 }
 
 type BuildConfig struct {
-	From      string            `yaml:"from"`
-	Tags      []string          `yaml:"tags"`
-	Args      map[string]string `yaml:"args"`
-	Scripts   []BuildScript     `yaml:"scripts"`
-	CacheFrom []string          `yaml:"cache_from"`
-	Labels    map[string]string `yaml:"labels"`
+	Extends   BuildExtends        `yaml:"extends,omitempty"`
+	From      string              `yaml:"from,omitempty"`
+	Tags      []string            `yaml:"tags,omitempty"`
+	Args      map[string]string   `yaml:"args,omitempty"`
+	Scripts   []BuildScript       `yaml:"scripts,omitempty"`
+	CacheFrom []string            `yaml:"cache_from,omitempty"`
+	Labels    map[string]string   `yaml:"labels,omitempty"`
+	Inputs    []string            `yaml:"inputs,omitempty"`
+	Matrix    map[string][]string `yaml:"matrix,omitempty"`
+	Platforms []string            `yaml:"platforms,omitempty"`
+	Secrets   []string            `yaml:"secrets,omitempty"`
+
+	// Matrix values of a build generated from a matrix
+	MatrixValues map[string]string `yaml:"-"`
+
+	// Positions of the build and its fields in config files
+	positions map[string]Position
 }
 
+func (b *BuildConfig) setPosition(field string, pos Position) {
+	if b.positions == nil {
+		b.positions = map[string]Position{}
+	}
+
+	b.positions[field] = pos
+}
+
+// position returns the position of the field. It returns the position of the
+// build if the field is not set in config files.
+func (b BuildConfig) position(field string) Position {
+	if pos, ok := b.positions[field]; ok {
+		return pos
+	}
+
+	return b.positions[""]
+}
+
+// TargetPlatforms returns platforms of images to build. Builds without
+// platforms are built for the platform of Docker, which is an empty string.
+func (b BuildConfig) TargetPlatforms() []string {
+	if len(b.Platforms) == 0 {
+		return []string{""}
+	}
+
+	return b.Platforms
+}
+
+// PlatformTags returns tags of the image built for the platform. Images of
+// builds for multiple platforms are tagged with platform suffixes, and their
+// tags are pushed as manifest lists.
+func (b BuildConfig) PlatformTags(platform string) ([]string, error) {
+	if len(b.Platforms) < 2 {
+		return b.Tags, nil
+	}
+
+	result := make([]string, len(b.Tags))
+
+	for i, tag := range b.Tags {
+		var err error
+
+		if result[i], err = platformTag(tag, platform); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// Dockerfile returns the Dockerfile of the build. The experimental syntax of
+// BuildKit is enabled if any RUN instructions have mounts.
 func (b BuildConfig) Dockerfile() string {
+	var lines []string
+
+	for _, script := range b.Scripts {
+		if script.hasMounts() {
+			lines = append(lines, "# syntax = "+dockerfileExperimentalSyntax)
+			break
+		}
+	}
+
 	// nolint: gosec
-	lines := []string{"FROM " + b.From}
+	lines = append(lines, "FROM "+b.From)
 
 	for _, script := range b.Scripts {
 		lines = append(lines, script.Dockerfile())
@@ -126,10 +538,23 @@ func (b BuildConfig) FindImports() StringSet {
 }
 
 type BuildScript struct {
-	Raw         string
-	Instruction string
-	Value       string
-	Import      string
+	Raw           string
+	Instruction   string
+	Value         string
+	Import        string
+	ImportOptions ImportOptions
+
+	// Mounts of the RUN instruction in the format of the --mount flag
+	Mounts []string
+
+	// Position of the script in config files
+	pos Position
+}
+
+func (b *BuildScript) setPosition(field string, pos Position) {
+	if field == "" {
+		b.pos = pos
+	}
 }
 
 func (b BuildScript) Dockerfile() string {
@@ -138,32 +563,191 @@ func (b BuildScript) Dockerfile() string {
 	}
 
 	if b.Import != "" {
-		return fmt.Sprintf("ADD %s/%s.tar /", layercakeBaseDir, b.Import)
+		dest := b.ImportOptions.To
+
+		if dest == "" {
+			dest = "/"
+		}
+
+		return fmt.Sprintf("ADD %s/%s %s", layercakeBaseDir, b.ImportFile(), dest)
 	}
 
-	return b.Instruction + " " + b.Value
+	var flags string
+
+	for _, mount := range b.Mounts {
+		flags += "--mount=" + mount + " "
+	}
+
+	return b.Instruction + " " + flags + b.Value
 }
 
-func (b *BuildScript) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	var s string
+// hasMounts returns true if the script is a RUN instruction with mounts, which
+// requires the experimental syntax of BuildKit.
+func (b BuildScript) hasMounts() bool {
+	if len(b.Mounts) > 0 {
+		return true
+	}
+
+	return b.instruction() == "RUN" && strings.Contains(b.Raw, "--mount=")
+}
 
-	if err := unmarshal(&s); err == nil {
-		b.Raw = s
-		return nil
+// MountedSecrets returns IDs of secrets mounted in the script. IDs default to
+// base names of targets as in Dockerfiles. Mounts in raw scripts are ignored.
+func (b BuildScript) MountedSecrets() []string {
+	var result []string
+
+	for _, mount := range b.Mounts {
+		options, err := parseMountOptions(mount)
+
+		if err != nil || options["type"] != "secret" {
+			continue
+		}
+
+		id := options["id"]
+
+		if id == "" {
+			id = path.Base(options["target"])
+		}
+
+		if id != "" && id != "." && id != "/" {
+			result = append(result, id)
+		}
 	}
 
-	var m yaml.MapSlice
+	return result
+}
 
-	if err := unmarshal(&m); err != nil {
-		return err
+// instruction returns the Dockerfile instruction of the script in upper case.
+// It returns an empty string for comments.
+func (b BuildScript) instruction() string {
+	if b.Import != "" {
+		return "ADD"
+	}
+
+	if b.Raw == "" {
+		return strings.ToUpper(b.Instruction)
+	}
+
+	fields := strings.Fields(b.Raw)
+
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return ""
+	}
+
+	return strings.ToUpper(fields[0])
+}
+
+// ImportFile returns the name of the imported tar in the build context.
+func (b BuildScript) ImportFile() string {
+	if b.ImportOptions.IsZero() {
+		return b.Import + ".tar"
+	}
+
+	data, _ := json.Marshal(b.ImportOptions)
+	sum := sha256.Sum256(data)
+
+	return b.Import + "-" + hex.EncodeToString(sum[:6]) + ".tar"
+}
+
+// ImportOptions defines files imported from another build.
+type ImportOptions struct {
+	// Layers of the build to import. See LayerSet for the default value.
+	Layers string `yaml:"layers,omitempty" json:"layers,omitempty"`
+
+	// Paths of files or directories to import. All files in layers are
+	// imported if it's empty.
+	Paths []string `yaml:"paths,omitempty" json:"paths,omitempty"`
+
+	// Destination of imported files. Imported files keep their full paths if
+	// it's empty, otherwise they are copied into the destination with their
+	// base names.
+	To string `yaml:"to,omitempty" json:"to,omitempty"`
+}
+
+func (i ImportOptions) IsZero() bool {
+	return i.Layers == "" && len(i.Paths) == 0 && i.To == ""
+}
+
+// LayerSet returns the layer set of the build where files are imported from.
+// The last layer is imported by default. If paths are specified, they are
+// selected from the whole file system by default.
+func (i ImportOptions) LayerSet() string {
+	if i.Layers != "" {
+		return i.Layers
+	}
+
+	if len(i.Paths) == 0 {
+		return LayerSetLast
+	}
+
+	return LayerSetAll
+}
+
+// MapPath returns the path of the file in the imported tar. Both the input and
+// the output are relative paths without leading slashes. It returns false if
+// the file is not imported.
+func (i ImportOptions) MapPath(name string) (string, bool) {
+	if len(i.Paths) == 0 {
+		return name, true
+	}
+
+	for _, p := range i.Paths {
+		p = normalizeTarName(p)
+
+		// Import the whole file system
+		if p == "" {
+			return name, true
+		}
+
+		if name != p && !strings.HasPrefix(name, p+"/") {
+			continue
+		}
+
+		if i.To == "" {
+			return name, true
+		}
+
+		return path.Join(path.Base(p), strings.TrimPrefix(name, p)), true
 	}
 
-	if len(m) == 0 {
+	return "", false
+}
+
+// runScript is a RUN instruction with mounts.
+type runScript struct {
+	Run   string   `yaml:"run"`
+	Mount []string `yaml:"mount"`
+}
+
+type importScript struct {
+	From          string `yaml:"from,omitempty"`
+	ImportOptions `yaml:",inline"`
+}
+
+func (b *BuildScript) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		b.Raw = node.Value
+		return nil
+	}
+
+	if node.Kind != yaml.MappingNode || len(node.Content) == 0 {
 		return errors.New("build script should be a string or a map")
 	}
 
-	item := m[0]
-	key, err := b.encode(item.Key)
+	keyNode, valueNode := node.Content[0], node.Content[1]
+	var mountNode *yaml.Node
+
+	// Mounts of RUN instructions are defined in the same map
+	if len(node.Content) == 4 {
+		for i := 0; i < 4; i += 2 {
+			if strings.EqualFold(node.Content[i].Value, "mount") {
+				mountNode = node.Content[i+1]
+				keyNode, valueNode = node.Content[2-i], node.Content[3-i]
+			}
+		}
+	}
+
+	key, err := b.encode(keyNode)
 
 	if err != nil {
 		return err
@@ -171,35 +755,205 @@ func (b *BuildScript) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 	key = strings.ToUpper(key)
 
-	if key == "IMPORT" {
-		b.Import = item.Value.(string)
-		return nil
+	if key == "IMPORT" && mountNode == nil {
+		return b.decodeImport(valueNode)
 	}
 
-	value, err := b.encode(item.Value)
+	value, err := b.encode(valueNode)
 
 	if err != nil {
 		return err
 	}
 
+	if mountNode != nil {
+		if key != "RUN" {
+			return errors.New("mount is only supported by RUN instructions")
+		}
+
+		if b.Mounts, err = b.decodeMounts(mountNode); err != nil {
+			return err
+		}
+	}
+
 	b.Instruction = key
 	b.Value = value
 
 	return nil
 }
 
-func (b BuildScript) encode(data interface{}) (string, error) {
-	if m, ok := data.(yaml.MapSlice); ok {
+// decodeMounts decodes a mount or a list of mounts. Each mount is either a
+// string in the format of the --mount flag or a map of its options.
+func (b *BuildScript) decodeMounts(node *yaml.Node) ([]string, error) {
+	if node.Kind == yaml.AliasNode {
+		return b.decodeMounts(node.Alias)
+	}
+
+	nodes := []*yaml.Node{node}
+
+	if node.Kind == yaml.SequenceNode {
+		nodes = node.Content
+	}
+
+	var result []string
+
+	for _, n := range nodes {
+		if n.Kind == yaml.AliasNode {
+			n = n.Alias
+		}
+
+		if n.Kind == yaml.ScalarNode {
+			if _, err := parseMountOptions(n.Value); err != nil {
+				return nil, err
+			}
+
+			result = append(result, n.Value)
+			continue
+		}
+
+		if n.Kind != yaml.MappingNode {
+			return nil, errors.New("mount should be a string or a map")
+		}
+
+		var fields []string
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, err := b.encode(n.Content[i])
+
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := b.encode(n.Content[i+1])
+
+			if err != nil {
+				return nil, err
+			}
+
+			fields = append(fields, key+"="+value)
+		}
+
+		mount, err := formatMountOptions(fields)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, mount)
+	}
+
+	return result, nil
+}
+
+// parseMountOptions parses options of the --mount flag, which are key-value
+// pairs in CSV.
+func parseMountOptions(mount string) (map[string]string, error) {
+	fields, err := csv.NewReader(strings.NewReader(mount)).Read()
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid mount %q: %s", mount, err)
+	}
+
+	result := map[string]string{}
+
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid mount %q, options must be in the format of key=value", mount)
+		}
+
+		result[strings.ToLower(parts[0])] = parts[1]
+	}
+
+	return result, nil
+}
+
+// formatMountOptions returns the value of the --mount flag. Options are quoted
+// if they contain commas or quotes.
+func formatMountOptions(fields []string) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write(fields); err != nil {
+		return "", err
+	}
+
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// MarshalYAML returns the script in the same form as it's written in config
+// files.
+func (b BuildScript) MarshalYAML() (interface{}, error) {
+	switch {
+	case b.Raw != "":
+		return b.Raw, nil
+
+	case b.Import != "" && b.ImportOptions.IsZero():
+		return map[string]string{"import": b.Import}, nil
+
+	case b.Import != "":
+		return map[string]importScript{
+			"import": {From: b.Import, ImportOptions: b.ImportOptions},
+		}, nil
+
+	case len(b.Mounts) > 0:
+		return runScript{Run: b.Value, Mount: b.Mounts}, nil
+	}
+
+	return map[string]string{strings.ToLower(b.Instruction): b.Value}, nil
+}
+
+func (b *BuildScript) decodeImport(node *yaml.Node) error {
+	if node.ShortTag() == "!!str" {
+		b.Import = node.Value
+		return nil
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return errors.New("import should be a string or a map")
+	}
+
+	var script importScript
+
+	if err := decodeYAML("", node, &script); err != nil {
+		return err
+	}
+
+	if script.From == "" {
+		return errors.New("import must have a source build")
+	}
+
+	if l := script.Layers; l != "" && !stringSliceContains(layerSets, l) {
+		return fmt.Errorf("import layers should be one of %s", strings.Join(layerSets, ", "))
+	}
+
+	b.Import = script.From
+	b.ImportOptions = script.ImportOptions
+	return nil
+}
+
+func (b BuildScript) encode(node *yaml.Node) (string, error) {
+	if node.Kind == yaml.AliasNode {
+		return b.encode(node.Alias)
+	}
+
+	if node.Kind == yaml.MappingNode {
 		var result []string
 
-		for _, item := range m {
-			key, err := b.encode(item.Key)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, err := b.encode(node.Content[i])
 
 			if err != nil {
 				return "", err
 			}
 
-			value, err := b.encode(item.Value)
+			value, err := b.encode(node.Content[i+1])
 
 			if err != nil {
 				return "", err
@@ -211,6 +965,12 @@ func (b BuildScript) encode(data interface{}) (string, error) {
 		return strings.Join(result, " "), nil
 	}
 
+	var data interface{}
+
+	if err := node.Decode(&data); err != nil {
+		return "", err
+	}
+
 	v := reflect.ValueOf(data)
 
 	switch v.Kind() {
@@ -242,24 +1002,181 @@ func (b BuildScript) encode(data interface{}) (string, error) {
 	return "", fmt.Errorf("unsupported type %T in build script", data)
 }
 
+// LoadConfig parses the config. Included files are resolved relative to the
+// current working directory.
 func LoadConfig(data []byte) (*Config, error) {
-	var conf Config
+	loader := newConfigLoader()
+	loader.load(data, "", ".")
+
+	return loader.finish()
+}
+
+func LoadConfigFile(path string) (*Config, error) {
+	absPath, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
 
-	if err := yaml.UnmarshalStrict(data, &conf); err != nil {
+	loader := newConfigLoader()
+
+	if err := loader.loadFile(absPath); err != nil {
 		return nil, err
 	}
 
-	return &conf, nil
+	return loader.finish()
 }
 
-func LoadConfigFile(path string) (*Config, error) {
+// configLoader loads a config and its included files, and merges them into a
+// single config. Errors in all files are collected.
+type configLoader struct {
+	config  *Config
+	visited StringSet
+	errs    ConfigErrors
+}
+
+func newConfigLoader() *configLoader {
+	return &configLoader{
+		config:  &Config{},
+		visited: NewStringSet(),
+	}
+}
+
+// loadFile loads the config file. It only returns an error if the file can't
+// be read. Other errors are collected in the loader.
+func (l *configLoader) loadFile(path string) error {
+	// Files are loaded only once to avoid include loops
+	if l.visited.Contains(path) {
+		return nil
+	}
+
+	l.visited.Insert(path)
 	data, err := ioutil.ReadFile(path)
 
+	if err != nil {
+		return err
+	}
+
+	l.config.files = append(l.config.files, path)
+	l.load(data, path, filepath.Dir(path))
+
+	return nil
+}
+
+func (l *configLoader) load(data []byte, file, dir string) {
+	var node yaml.Node
+
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		l.errs = append(l.errs, newSyntaxError(file, err))
+		return
+	}
+
+	var conf Config
+
+	// Continue loading includes to collect errors in all files
+	if err := decodeYAML(file, &node, &conf); err != nil {
+		l.errs = append(l.errs, err.(ConfigErrors)...)
+	}
+
+	if l.config.positions == nil {
+		l.config.positions = conf.positions
+	}
+
+	l.errs = append(l.errs, mergeBuilds("build", &l.config.Build, conf.Build)...)
+	l.errs = append(l.errs, mergeBuilds("template", &l.config.Templates, conf.Templates)...)
+
+	// Variables of the including file take precedence over included files
+	for name, value := range conf.Variables {
+		if l.config.Variables == nil {
+			l.config.Variables = map[string]string{}
+		}
+
+		if _, ok := l.config.Variables[name]; !ok {
+			l.config.Variables[name] = value
+			l.config.setPosition("variables."+name, conf.variablePosition(name))
+		}
+	}
+
+	for _, pattern := range conf.Include {
+		paths, err := resolveInclude(dir, pattern)
+
+		if err != nil {
+			l.errs = append(l.errs, newConfigError(conf.position("include"), "unable to include %q: %s", pattern, err))
+			continue
+		}
+
+		for _, path := range paths {
+			if err := l.loadFile(path); err != nil {
+				l.errs = append(l.errs, newConfigError(conf.position("include"), "unable to include %q: %s", pattern, err))
+			}
+		}
+	}
+}
+
+func (l *configLoader) finish() (*Config, error) {
+	if err := l.errs.Err(); err != nil {
+		return nil, err
+	}
+
+	err := RunSeries(
+		l.config.ResolveExtends,
+		l.config.ExpandMatrix,
+		l.config.Interpolate,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return l.config, nil
+}
+
+// mergeBuilds copies builds in src to dst. It returns errors if a build is
+// defined more than once.
+func mergeBuilds(kind string, dst *map[string]BuildConfig, src map[string]BuildConfig) (errs ConfigErrors) {
+	for _, name := range sortedBuildNames(src) {
+		build := src[name]
+
+		if existing, ok := (*dst)[name]; ok {
+			errs = append(errs, newConfigError(build.position(""), "%s %q is already defined at %s", kind, name, existing.position("")))
+			continue
+		}
+
+		if *dst == nil {
+			*dst = map[string]BuildConfig{}
+		}
+
+		(*dst)[name] = build
+	}
+
+	return
+}
+
+// resolveInclude returns absolute paths of files matching the include pattern.
+// Relative patterns are resolved from dir.
+func resolveInclude(dir, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+
+	pattern, err := filepath.Abs(pattern)
+
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+
+	matches, err := filepath.Glob(pattern)
+
 	if err != nil {
 		return nil, err
 	}
 
-	return LoadConfig(data)
+	sort.Strings(matches)
+	return matches, nil
 }
 
 func InitConfig() (config *Config, err error) {
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// nolint: gochecknoglobals
+var (
+	yamlSyntaxErrorPattern = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+	yamlTypeErrorPattern   = regexp.MustCompile(`^line \d+: `)
+)
+
+// Position is a location in a config file. Line and column start from 1, and
+// they are 0 if unknown.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func nodePosition(file string, node *yaml.Node) Position {
+	return Position{
+		File:   file,
+		Line:   node.Line,
+		Column: node.Column,
+	}
+}
+
+func (p Position) String() string {
+	var parts []string
+
+	if p.File != "" {
+		parts = append(parts, p.File)
+	}
+
+	if p.Line > 0 {
+		parts = append(parts, strconv.Itoa(p.Line))
+
+		if p.Column > 0 {
+			parts = append(parts, strconv.Itoa(p.Column))
+		}
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// ConfigError is an error in a config file.
+type ConfigError struct {
+	Position
+	Message string
+}
+
+func newConfigError(pos Position, format string, args ...interface{}) *ConfigError {
+	return &ConfigError{
+		Position: pos,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *ConfigError) Error() string {
+	if pos := e.Position.String(); pos != "" {
+		return pos + ": " + e.Message
+	}
+
+	return e.Message
+}
+
+// ConfigErrors is a list of errors in config files.
+type ConfigErrors []*ConfigError
+
+// newConfigErrors converts the error returned from decoding the node into
+// config errors.
+func newConfigErrors(file string, node *yaml.Node, err error) ConfigErrors {
+	pos := nodePosition(file, node)
+
+	switch e := err.(type) {
+	case ConfigErrors:
+		for _, item := range e {
+			if item.File == "" {
+				item.File = file
+			}
+		}
+
+		return e
+
+	case *ConfigError:
+		return newConfigErrors(file, node, ConfigErrors{e})
+
+	case *yaml.TypeError:
+		var result ConfigErrors
+
+		for _, msg := range e.Errors {
+			result = append(result, newConfigError(pos, "%s", yamlTypeErrorPattern.ReplaceAllString(msg, "")))
+		}
+
+		return result
+	}
+
+	return ConfigErrors{newConfigError(pos, "%s", err.Error())}
+}
+
+// newSyntaxError converts the error returned from parsing YAML into a config
+// error.
+func newSyntaxError(file string, err error) *ConfigError {
+	pos := Position{File: file}
+	msg := err.Error()
+
+	if match := yamlSyntaxErrorPattern.FindStringSubmatch(msg); match != nil {
+		pos.Line, _ = strconv.Atoi(match[1])
+		msg = match[2]
+	}
+
+	return newConfigError(pos, "%s", strings.TrimPrefix(msg, "yaml: "))
+}
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Err sorts errors by their positions. It returns nil if the list is empty.
+func (e ConfigErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(e, func(i, j int) bool {
+		a, b := e[i].Position, e[j].Position
+
+		if a.File != b.File {
+			return a.File < b.File
+		}
+
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+
+		return a.Column < b.Column
+	})
+
+	return e
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigError_Error(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Position Position
+		Expected string
+	}{
+		{
+			Name:     "No position",
+			Expected: "foo",
+		},
+		{
+			Name:     "File",
+			Position: Position{File: "a.yml"},
+			Expected: "a.yml: foo",
+		},
+		{
+			Name:     "Line",
+			Position: Position{File: "a.yml", Line: 3},
+			Expected: "a.yml:3: foo",
+		},
+		{
+			Name:     "Column",
+			Position: Position{File: "a.yml", Line: 3, Column: 5},
+			Expected: "a.yml:3:5: foo",
+		},
+		{
+			Name:     "Without file",
+			Position: Position{Line: 3, Column: 5},
+			Expected: "3:5: foo",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			assert.EqualError(t, newConfigError(test.Position, "foo"), test.Expected)
+		})
+	}
+}
+
+func TestConfigErrors_Err(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		var errs ConfigErrors
+		assert.NoError(t, errs.Err())
+	})
+
+	t.Run("Sort", func(t *testing.T) {
+		errs := ConfigErrors{
+			newConfigError(Position{File: "b.yml", Line: 1, Column: 1}, "a"),
+			newConfigError(Position{File: "a.yml", Line: 2, Column: 1}, "b"),
+			newConfigError(Position{File: "a.yml", Line: 1, Column: 3}, "c"),
+			newConfigError(Position{File: "a.yml", Line: 1, Column: 1}, "d"),
+		}
+
+		assert.EqualError(t, errs.Err(), "a.yml:1:1: d\na.yml:1:3: c\na.yml:2:1: b\nb.yml:1:1: a")
+	})
+}
+
+func TestNewSyntaxError(t *testing.T) {
+	t.Run("With line", func(t *testing.T) {
+		err := newSyntaxError("a.yml", errors.New("yaml: line 3: mapping values are not allowed in this context"))
+		assert.Equal(t, newConfigError(Position{File: "a.yml", Line: 3}, "mapping values are not allowed in this context"), err)
+	})
+
+	t.Run("Without line", func(t *testing.T) {
+		err := newSyntaxError("a.yml", errors.New("yaml: control characters are not allowed"))
+		assert.Equal(t, newConfigError(Position{File: "a.yml"}, "control characters are not allowed"), err)
+	})
+}
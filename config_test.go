@@ -4,18 +4,53 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 func normalizeYAMLString(input string) string {
 	return strings.Replace(strings.TrimSpace(input), "\t", "  ", -1)
 }
 
+// assertConfig compares configs without positions recorded while decoding.
+func assertConfig(t *testing.T, expected, actual *Config) {
+	if actual != nil {
+		actual.positions = nil
+
+		for name, build := range actual.Build {
+			actual.Build[name] = clearBuildPositions(build)
+		}
+
+		for name, build := range actual.Templates {
+			actual.Templates[name] = clearBuildPositions(build)
+		}
+	}
+
+	assert.Equal(t, expected, actual)
+}
+
+func clearBuildPositions(build BuildConfig) BuildConfig {
+	build.positions = nil
+
+	if build.Scripts != nil {
+		scripts := make([]BuildScript, len(build.Scripts))
+
+		for i, script := range build.Scripts {
+			script.pos = Position{}
+			scripts[i] = script
+		}
+
+		build.Scripts = scripts
+	}
+
+	return build
+}
+
 func writeTempFile(content []byte) (*os.File, error) {
 	file, err := ioutil.TempFile("", "layercake")
 
@@ -77,6 +112,117 @@ func TestConfig_FindDependants(t *testing.T) {
 	assert.Equal(t, expected, config.FindDependants("foo"))
 }
 
+func TestConfig_FindAllDependencies(t *testing.T) {
+	config := Config{
+		Build: map[string]BuildConfig{
+			"a": {
+				Scripts: []BuildScript{
+					{Import: "b"},
+					{Import: "c"},
+				},
+			},
+			"b": {
+				Scripts: []BuildScript{
+					{Import: "d"},
+				},
+			},
+			"c": {},
+			"d": {},
+			"e": {},
+		},
+	}
+
+	assertStringSet(t, []string{"b", "c", "d"}, config.FindAllDependencies("a"))
+	assertStringSet(t, []string{"d"}, config.FindAllDependencies("b"))
+	assertStringSet(t, []string{}, config.FindAllDependencies("e"))
+}
+
+func TestConfig_FindAllDependants(t *testing.T) {
+	config := Config{
+		Build: map[string]BuildConfig{
+			"a": {
+				Scripts: []BuildScript{
+					{Import: "b"},
+				},
+			},
+			"b": {
+				Scripts: []BuildScript{
+					{Import: "c"},
+				},
+			},
+			"c": {},
+			"d": {
+				Scripts: []BuildScript{
+					{Import: "c"},
+				},
+			},
+		},
+	}
+
+	assertStringSet(t, []string{"a", "b", "d"}, config.FindAllDependants("c"))
+	assertStringSet(t, []string{}, config.FindAllDependants("a"))
+}
+
+func TestConfig_FindAffectedBuilds(t *testing.T) {
+	config := Config{
+		Build: map[string]BuildConfig{
+			"a": {
+				Inputs: []string{"a"},
+			},
+			"b": {
+				Inputs: []string{"b/*.go"},
+				Scripts: []BuildScript{
+					{Import: "a"},
+				},
+			},
+			"c": {
+				Inputs: []string{"c"},
+				Scripts: []BuildScript{
+					{Import: "b"},
+				},
+			},
+			"d": {
+				Inputs: []string{"d"},
+			},
+			"e": {},
+		},
+	}
+
+	tests := []struct {
+		Name     string
+		Files    []string
+		Expected []string
+	}{
+		{
+			Name:     "No changes",
+			Expected: []string{"e"},
+		},
+		{
+			Name:     "Dependants",
+			Files:    []string{"a/main.go"},
+			Expected: []string{"a", "b", "c", "e"},
+		},
+		{
+			Name:     "Glob",
+			Files:    []string{"b/main.go", "b/README.md"},
+			Expected: []string{"b", "c", "e"},
+		},
+		{
+			Name:     "Not matched",
+			Files:    []string{"b/README.md", "f/main.go"},
+			Expected: []string{"e"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			assertStringSet(t, test.Expected, config.FindAffectedBuilds(test.Files))
+		})
+	}
+}
+
 func TestConfig_SortBuilds(t *testing.T) {
 	config := Config{
 		Build: map[string]BuildConfig{
@@ -94,9 +240,156 @@ func TestConfig_SortBuilds(t *testing.T) {
 		},
 	}
 
-	expected := NewOrderedStringSet()
-	expected.Insert("c", "a", "b")
-	assert.Equal(t, expected, config.SortBuilds())
+	t.Run("Success", func(t *testing.T) {
+		actual, err := config.SortBuilds()
+		require.NoError(t, err)
+
+		expected := NewOrderedStringSet()
+		expected.Insert("c", "a", "b")
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		config := Config{
+			Build: map[string]BuildConfig{
+				"a": {
+					Scripts: []BuildScript{
+						{Import: "b"},
+					},
+				},
+				"b": {
+					Scripts: []BuildScript{
+						{Import: "a"},
+					},
+				},
+				"c": {},
+			},
+		}
+
+		actual, err := config.SortBuilds()
+		assert.EqualError(t, err, "dependency cycle detected: a -> b -> a")
+		assert.Nil(t, actual)
+	})
+
+	t.Run("Undefined import", func(t *testing.T) {
+		config := Config{
+			Build: map[string]BuildConfig{
+				"a": {
+					Scripts: []BuildScript{
+						{Import: "b"},
+					},
+				},
+			},
+		}
+
+		actual, err := config.SortBuilds()
+		assert.EqualError(t, err, "unable to resolve dependencies of a")
+		assert.Nil(t, actual)
+	})
+}
+
+func TestConfig_SortBuildsWithDependencies(t *testing.T) {
+	config := &Config{
+		Build: map[string]BuildConfig{
+			"a": {},
+			"b": {Scripts: []BuildScript{{Import: "d"}, {Import: "c"}}},
+			"c": {Scripts: []BuildScript{{Import: "d"}}},
+			"d": {},
+			"e": {Scripts: []BuildScript{{Import: "a"}}},
+		},
+	}
+
+	t.Run("All builds", func(t *testing.T) {
+		actual, err := config.SortBuildsWithDependencies(nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "d", "c", "b", "e"}, actual)
+	})
+
+	t.Run("Selected builds", func(t *testing.T) {
+		actual, err := config.SortBuildsWithDependencies([]string{"c", "e"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"d", "c", "a", "e"}, actual)
+	})
+
+	t.Run("Undefined build", func(t *testing.T) {
+		_, err := config.SortBuildsWithDependencies([]string{"f"})
+		assert.EqualError(t, err, `build "f" is not defined`)
+	})
+}
+
+func TestConfig_BuildNames(t *testing.T) {
+	config := Config{
+		Build: map[string]BuildConfig{
+			"c": {},
+			"a": {},
+			"b": {},
+		},
+	}
+
+	assert.Equal(t, []string{"a", "b", "c"}, config.BuildNames())
+}
+
+func TestConfig_FindCycle(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Imports  map[string][]string
+		Expected []string
+	}{
+		{
+			Name: "No cycles",
+			Imports: map[string][]string{
+				"a": {"b", "c"},
+				"b": {"c"},
+				"c": {},
+			},
+		},
+		{
+			Name: "Self import",
+			Imports: map[string][]string{
+				"a": {"a"},
+			},
+			Expected: []string{"a", "a"},
+		},
+		{
+			Name: "Indirect",
+			Imports: map[string][]string{
+				"a": {"b"},
+				"b": {"c"},
+				"c": {"d", "a"},
+				"d": {},
+			},
+			Expected: []string{"a", "b", "c", "a"},
+		},
+		{
+			Name: "Cycle not including the first build",
+			Imports: map[string][]string{
+				"a": {"b"},
+				"b": {"c"},
+				"c": {"b"},
+			},
+			Expected: []string{"b", "c", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			config := Config{Build: map[string]BuildConfig{}}
+
+			for name, imports := range test.Imports {
+				var scripts []BuildScript
+
+				for _, i := range imports {
+					scripts = append(scripts, BuildScript{Import: i})
+				}
+
+				config.Build[name] = BuildConfig{Scripts: scripts}
+			}
+
+			assert.Equal(t, test.Expected, config.FindCycle())
+		})
+	}
 }
 
 func TestConfig_Validate(t *testing.T) {
@@ -140,7 +433,216 @@ func TestConfig_Validate(t *testing.T) {
 			},
 		}
 
-		assert.Error(t, config.Validate())
+		assert.Error(t, config.Validate())
+	})
+
+	t.Run("Multiple errors", func(t *testing.T) {
+		config, err := LoadConfig([]byte(normalizeYAMLString(`
+build:
+	foo:
+		from: alpine
+		scripts:
+			- import: baz
+	bar:
+		scripts:
+			- RUN bar
+`)))
+		require.NoError(t, err)
+
+		assert.EqualError(t, config.Validate(), `5:9: build "foo" contains undefined import "baz"
+6:3: build "bar" must have a base image`)
+	})
+
+	t.Run("Invalid tags", func(t *testing.T) {
+		config, err := LoadConfig([]byte(normalizeYAMLString(`
+build:
+	foo:
+		from: alpine
+		tags:
+			- foo@sha256:` + strings.Repeat("0", 64) + `
+			- Foo
+`)))
+		require.NoError(t, err)
+
+		assert.EqualError(t, config.Validate(), `5:7: build "foo" has tag "foo@sha256:`+strings.Repeat("0", 64)+`" with a digest
+5:7: build "foo" has invalid tag "Foo": invalid reference format: repository name must be lowercase`)
+	})
+
+	t.Run("Extends cycle", func(t *testing.T) {
+		config := &Config{
+			Build: map[string]BuildConfig{
+				"a": {From: "alpine", Extends: BuildExtends{Name: "b"}},
+				"b": {From: "alpine", Extends: BuildExtends{Name: "a"}},
+			},
+		}
+
+		assert.EqualError(t, config.Validate(), "extends cycle detected: a -> b -> a")
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		config := Config{
+			Build: map[string]BuildConfig{
+				"foo": {
+					From: "busybox",
+					Scripts: []BuildScript{
+						{Import: "bar"},
+					},
+				},
+				"bar": {
+					From: "busybox",
+					Scripts: []BuildScript{
+						{Import: "foo"},
+					},
+				},
+			},
+		}
+
+		assert.EqualError(t, config.Validate(), "dependency cycle detected: bar -> foo -> bar")
+	})
+
+	t.Run("Invalid platform", func(t *testing.T) {
+		config := Config{
+			Build: map[string]BuildConfig{
+				"foo": {
+					From:      "busybox",
+					Platforms: []string{"linux"},
+				},
+			},
+		}
+
+		assert.EqualError(t, config.Validate(), `build "foo" has invalid platform "linux", it must be in the format of os/arch[/variant]`)
+	})
+
+	t.Run("Undeclared secret", func(t *testing.T) {
+		config := Config{
+			Build: map[string]BuildConfig{
+				"foo": {
+					From:    "busybox",
+					Secrets: []string{"npmrc"},
+					Scripts: []BuildScript{
+						{Instruction: "RUN", Value: "make", Mounts: []string{"type=secret,id=npmrc", "type=secret,id=netrc"}},
+					},
+				},
+			},
+		}
+
+		assert.EqualError(t, config.Validate(), `build "foo" mounts undeclared secret "netrc"`)
+	})
+
+	t.Run("Import platform not built", func(t *testing.T) {
+		config := Config{
+			Build: map[string]BuildConfig{
+				"foo": {
+					From:      "busybox",
+					Platforms: []string{"linux/amd64", "linux/arm64"},
+					Scripts: []BuildScript{
+						{Import: "bar"},
+					},
+				},
+				"bar": {
+					From:      "busybox",
+					Platforms: []string{"linux/amd64"},
+				},
+			},
+		}
+
+		assert.EqualError(t, config.Validate(), `build "foo": build "bar" is not built for platform linux/arm64`)
+	})
+}
+
+func TestConfig_ImportPlatform(t *testing.T) {
+	config := &Config{
+		Build: map[string]BuildConfig{
+			"none":   {},
+			"single": {Platforms: []string{"linux/arm64"}},
+			"multi":  {Platforms: []string{"linux/amd64", "linux/arm/v7"}},
+		},
+	}
+
+	tests := []struct {
+		Name     string
+		Import   string
+		Platform string
+		Expected string
+		Error    string
+	}{
+		{
+			Name:     "No platforms",
+			Import:   "none",
+			Platform: "linux/amd64",
+			Expected: "",
+		},
+		{
+			Name:     "Same platform",
+			Import:   "multi",
+			Platform: "LINUX/ARM/V7",
+			Expected: "linux/arm/v7",
+		},
+		{
+			Name:     "Single platform",
+			Import:   "single",
+			Expected: "linux/arm64",
+		},
+		{
+			Name:   "Multiple platforms",
+			Import: "multi",
+			Error:  `build "multi" is built for multiple platforms, so builds importing it must specify platforms`,
+		},
+		{
+			Name:     "Platform not built",
+			Import:   "single",
+			Platform: "linux/amd64",
+			Error:    `build "single" is not built for platform linux/amd64`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			actual, err := config.ImportPlatform(test.Import, test.Platform)
+
+			if test.Error != "" {
+				assert.EqualError(t, err, test.Error)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, test.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestBuildConfig_PlatformTags(t *testing.T) {
+	t.Run("Single platform", func(t *testing.T) {
+		config := BuildConfig{
+			Tags:      []string{"foo:1.0"},
+			Platforms: []string{"linux/arm64"},
+		}
+
+		tags, err := config.PlatformTags("linux/arm64")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"foo:1.0"}, tags)
+	})
+
+	t.Run("Multiple platforms", func(t *testing.T) {
+		config := BuildConfig{
+			Tags:      []string{"foo:1.0", "gcr.io/bar/foo"},
+			Platforms: []string{"linux/amd64", "linux/arm64"},
+		}
+
+		tags, err := config.PlatformTags("linux/arm64")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"foo:1.0-linux-arm64", "gcr.io/bar/foo:latest-linux-arm64"}, tags)
+	})
+
+	t.Run("Digest", func(t *testing.T) {
+		config := BuildConfig{
+			Tags:      []string{"foo@sha256:" + strings.Repeat("0", 64)},
+			Platforms: []string{"linux/amd64", "linux/arm64"},
+		}
+
+		_, err := config.PlatformTags("linux/arm64")
+		assert.Error(t, err)
 	})
 }
 
@@ -160,6 +662,21 @@ CMD bar
 `), config.Dockerfile())
 }
 
+func TestBuildConfig_Dockerfile_mounts(t *testing.T) {
+	config := BuildConfig{
+		From: "alpine",
+		Scripts: []BuildScript{
+			{Instruction: "RUN", Value: "foo", Mounts: []string{"type=ssh"}},
+		},
+	}
+
+	assert.Equal(t, strings.TrimSpace(`
+# syntax = docker/dockerfile:1.0-experimental
+FROM alpine
+RUN --mount=type=ssh foo
+`), config.Dockerfile())
+}
+
 func TestBuildConfig_FindImports(t *testing.T) {
 	config := BuildConfig{
 		Scripts: []BuildScript{
@@ -186,10 +703,174 @@ func TestBuildScript_Dockerfile(t *testing.T) {
 		assert.Equal(t, fmt.Sprintf("ADD %s/%s.tar /", layercakeBaseDir, script.Import), script.Dockerfile())
 	})
 
+	t.Run("Import with options", func(t *testing.T) {
+		script := BuildScript{
+			Import: "foo",
+			ImportOptions: ImportOptions{
+				Paths: []string{"/usr/bin/foo"},
+				To:    "/usr/local/bin",
+			},
+		}
+		assert.Equal(t, fmt.Sprintf("ADD %s/%s /usr/local/bin", layercakeBaseDir, script.ImportFile()), script.Dockerfile())
+	})
+
 	t.Run("Instruction", func(t *testing.T) {
 		script := BuildScript{Instruction: "RUN", Value: "bar"}
 		assert.Equal(t, "RUN bar", script.Dockerfile())
 	})
+
+	t.Run("Mounts", func(t *testing.T) {
+		script := BuildScript{
+			Instruction: "RUN",
+			Value:       "npm install",
+			Mounts:      []string{"type=secret,id=npmrc,target=/root/.npmrc", "type=ssh"},
+		}
+		assert.Equal(t, "RUN --mount=type=secret,id=npmrc,target=/root/.npmrc --mount=type=ssh npm install", script.Dockerfile())
+	})
+}
+
+func TestBuildScript_MountedSecrets(t *testing.T) {
+	script := BuildScript{
+		Instruction: "RUN",
+		Value:       "make",
+		Mounts: []string{
+			"type=secret,id=npmrc",
+			"type=secret,target=/root/.netrc",
+			"type=ssh",
+			"type=cache,target=/root/.cache",
+		},
+	}
+
+	assert.Equal(t, []string{"npmrc", ".netrc"}, script.MountedSecrets())
+	assert.Empty(t, BuildScript{Raw: "RUN --mount=type=secret,id=foo make"}.MountedSecrets())
+}
+
+func TestBuildScript_instruction(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Script   BuildScript
+		Expected string
+	}{
+		{Name: "Raw", Script: BuildScript{Raw: "run echo foo"}, Expected: "RUN"},
+		{Name: "Comment", Script: BuildScript{Raw: "# foo"}, Expected: ""},
+		{Name: "Empty", Script: BuildScript{Raw: " "}, Expected: ""},
+		{Name: "Instruction", Script: BuildScript{Instruction: "env", Value: "a=b"}, Expected: "ENV"},
+		{Name: "Import", Script: BuildScript{Import: "foo"}, Expected: "ADD"},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, test.Script.instruction())
+		})
+	}
+}
+
+func TestBuildScript_ImportFile(t *testing.T) {
+	t.Run("No options", func(t *testing.T) {
+		script := BuildScript{Import: "foo"}
+		assert.Equal(t, "foo.tar", script.ImportFile())
+	})
+
+	t.Run("With options", func(t *testing.T) {
+		a := BuildScript{Import: "foo", ImportOptions: ImportOptions{Paths: []string{"/a"}}}
+		b := BuildScript{Import: "foo", ImportOptions: ImportOptions{Paths: []string{"/b"}}}
+		assert.Regexp(t, `^foo-[0-9a-f]{12}\.tar$`, a.ImportFile())
+		assert.NotEqual(t, a.ImportFile(), b.ImportFile())
+		assert.Equal(t, a.ImportFile(), a.ImportFile())
+	})
+}
+
+func TestImportOptions_LayerSet(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Options  ImportOptions
+		Expected string
+	}{
+		{
+			Name:     "Default",
+			Expected: LayerSetLast,
+		},
+		{
+			Name:     "Paths",
+			Options:  ImportOptions{Paths: []string{"/foo"}},
+			Expected: LayerSetAll,
+		},
+		{
+			Name:     "Specified",
+			Options:  ImportOptions{Layers: LayerSetSinceBase, Paths: []string{"/foo"}},
+			Expected: LayerSetSinceBase,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, test.Options.LayerSet())
+		})
+	}
+}
+
+func TestImportOptions_MapPath(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Options  ImportOptions
+		Input    string
+		Expected string
+		OK       bool
+	}{
+		{
+			Name:     "No paths",
+			Input:    "usr/bin/foo",
+			Expected: "usr/bin/foo",
+			OK:       true,
+		},
+		{
+			Name:     "Keep full paths",
+			Options:  ImportOptions{Paths: []string{"/usr/bin"}},
+			Input:    "usr/bin/foo",
+			Expected: "usr/bin/foo",
+			OK:       true,
+		},
+		{
+			Name:    "Not matched",
+			Options: ImportOptions{Paths: []string{"/usr/bin"}},
+			Input:   "usr/binary",
+		},
+		{
+			Name:     "File with destination",
+			Options:  ImportOptions{Paths: []string{"/usr/local/bin/hello"}, To: "/usr/bin"},
+			Input:    "usr/local/bin/hello",
+			Expected: "hello",
+			OK:       true,
+		},
+		{
+			Name:     "Directory with destination",
+			Options:  ImportOptions{Paths: []string{"/foo", "/usr/lib/"}, To: "/opt"},
+			Input:    "usr/lib/a/b.so",
+			Expected: "lib/a/b.so",
+			OK:       true,
+		},
+		{
+			Name:     "Root with destination",
+			Options:  ImportOptions{Paths: []string{"/"}, To: "/opt"},
+			Input:    "usr/lib",
+			Expected: "usr/lib",
+			OK:       true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			actual, ok := test.Options.MapPath(test.Input)
+			assert.Equal(t, test.OK, ok)
+			assert.Equal(t, test.Expected, actual)
+		})
+	}
 }
 
 func TestBuildScript_UnmarshalYAML(t *testing.T) {
@@ -208,6 +889,32 @@ func TestBuildScript_UnmarshalYAML(t *testing.T) {
 			Input:    "import: foo",
 			Expected: BuildScript{Import: "foo"},
 		},
+		{
+			Name: "Import: map",
+			Input: normalizeYAMLString(`
+import:
+	from: foo
+	paths: [/usr/bin/foo]
+	to: /usr/local/bin
+`),
+			Expected: BuildScript{
+				Import: "foo",
+				ImportOptions: ImportOptions{
+					Paths: []string{"/usr/bin/foo"},
+					To:    "/usr/local/bin",
+				},
+			},
+		},
+		{
+			Name:  "Import: layers",
+			Input: "import: {from: foo, layers: since-base}",
+			Expected: BuildScript{
+				Import: "foo",
+				ImportOptions: ImportOptions{
+					Layers: LayerSetSinceBase,
+				},
+			},
+		},
 		{
 			Name:     "Instruction: string",
 			Input:    "run: foo",
@@ -242,6 +949,43 @@ env:
 `),
 			Expected: BuildScript{Instruction: "ENV", Value: `a="b" c="d"`},
 		},
+		{
+			Name:  "Mount: string",
+			Input: "{run: go mod download, mount: type=ssh}",
+			Expected: BuildScript{
+				Instruction: "RUN",
+				Value:       "go mod download",
+				Mounts:      []string{"type=ssh"},
+			},
+		},
+		{
+			Name: "Mount: list",
+			Input: normalizeYAMLString(`
+mount:
+	- type: secret
+		id: netrc
+		target: /root/.netrc
+	- type=cache,target=/go/pkg/mod
+run: go mod download
+`),
+			Expected: BuildScript{
+				Instruction: "RUN",
+				Value:       "go mod download",
+				Mounts: []string{
+					"type=secret,id=netrc,target=/root/.netrc",
+					"type=cache,target=/go/pkg/mod",
+				},
+			},
+		},
+		{
+			Name:  "Mount: quoted",
+			Input: "{run: foo, mount: {type: bind, target: '/a,b'}}",
+			Expected: BuildScript{
+				Instruction: "RUN",
+				Value:       "foo",
+				Mounts:      []string{`type=bind,"target=/a,b"`},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -254,6 +998,85 @@ env:
 			assert.Equal(t, test.Expected, actual)
 		})
 	}
+
+	errorTests := []struct {
+		Name  string
+		Input string
+	}{
+		{
+			Name:  "Import: no source",
+			Input: "import: {paths: [/foo]}",
+		},
+		{
+			Name:  "Import: unknown field",
+			Input: "import: {from: foo, bar: baz}",
+		},
+		{
+			Name:  "Import: unknown layers",
+			Input: "import: {from: foo, layers: foo}",
+		},
+		{
+			Name:  "Import: invalid type",
+			Input: "import: [foo]",
+		},
+		{
+			Name:  "Mount: not RUN",
+			Input: "{copy: a b, mount: type=ssh}",
+		},
+		{
+			Name:  "Mount: invalid option",
+			Input: "{run: foo, mount: secret}",
+		},
+		{
+			Name:  "Mount: invalid type",
+			Input: "{run: foo, mount: [[type=ssh]]}",
+		},
+	}
+
+	for _, test := range errorTests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			var actual BuildScript
+			assert.Error(t, yaml.Unmarshal([]byte(test.Input), &actual))
+		})
+	}
+}
+
+func TestBuildScript_MarshalYAML(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Script   BuildScript
+		Expected string
+	}{
+		{Name: "Raw", Script: BuildScript{Raw: "RUN echo foo"}, Expected: "RUN echo foo\n"},
+		{Name: "Instruction", Script: BuildScript{Instruction: "RUN", Value: "echo foo"}, Expected: "run: echo foo\n"},
+		{Name: "Import", Script: BuildScript{Import: "foo"}, Expected: "import: foo\n"},
+		{
+			Name:     "Import with options",
+			Script:   BuildScript{Import: "foo", ImportOptions: ImportOptions{Paths: []string{"/a"}, To: "/b"}},
+			Expected: "import:\n    from: foo\n    paths:\n        - /a\n    to: /b\n",
+		},
+		{
+			Name:     "Mount",
+			Script:   BuildScript{Instruction: "RUN", Value: "echo foo", Mounts: []string{"type=ssh"}},
+			Expected: "run: echo foo\nmount:\n    - type=ssh\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			data, err := yaml.Marshal(test.Script)
+			require.NoError(t, err)
+			assert.Equal(t, test.Expected, string(data))
+
+			var actual BuildScript
+			require.NoError(t, yaml.Unmarshal(data, &actual))
+			assert.Equal(t, test.Script, actual)
+		})
+	}
 }
 
 func TestLoadConfig(t *testing.T) {
@@ -267,7 +1090,7 @@ build:
 `)))
 
 		require.NoError(t, err)
-		assert.Equal(t, &Config{
+		assertConfig(t, &Config{
 			Build: map[string]BuildConfig{
 				"foo": {
 					From: "alpine",
@@ -277,6 +1100,88 @@ build:
 		}, config)
 	})
 
+	t.Run("Extends", func(t *testing.T) {
+		config, err := LoadConfig([]byte(normalizeYAMLString(`
+variables:
+	VERSION: "3.9"
+templates:
+	base:
+		from: alpine:${VERSION}
+		scripts:
+			- RUN apk update
+build:
+	foo:
+		extends: base
+		scripts:
+			- RUN foo
+`)))
+
+		require.NoError(t, err)
+		assert.Equal(t, BuildConfig{
+			From: "alpine:3.9",
+			Scripts: []BuildScript{
+				{Raw: "RUN apk update"},
+				{Raw: "RUN foo"},
+			},
+		}, clearBuildPositions(config.Build["foo"]))
+	})
+
+	t.Run("Extends cycle", func(t *testing.T) {
+		config, err := LoadConfig([]byte(normalizeYAMLString(`
+templates:
+	base:
+		extends: foo
+		from: alpine
+build:
+	foo:
+		extends: base
+`)))
+
+		assert.EqualError(t, err, "7:14: extends cycle detected: foo -> base -> foo")
+		assert.Nil(t, config)
+	})
+
+	t.Run("Matrix", func(t *testing.T) {
+		config, err := LoadConfig([]byte(normalizeYAMLString(`
+build:
+	foo:
+		matrix:
+			version: ["3.8", "3.9"]
+		from: alpine:${version}
+		tags:
+			- foo:${version}
+`)))
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"foo-3.8", "foo-3.9"}, config.BuildNames())
+		assert.Equal(t, "alpine:3.9", config.Build["foo-3.9"].From)
+		assert.Equal(t, []string{"foo:3.9"}, config.Build["foo-3.9"].Tags)
+	})
+
+	t.Run("Variables", func(t *testing.T) {
+		config, err := LoadConfig([]byte(normalizeYAMLString(`
+variables:
+	VERSION: "3.9"
+build:
+	foo: 
+		from: alpine:${VERSION}
+`)))
+
+		require.NoError(t, err)
+		assert.Equal(t, "alpine:3.9", config.Build["foo"].From)
+	})
+
+	t.Run("Undefined variable", func(t *testing.T) {
+		config, err := LoadConfig([]byte(normalizeYAMLString(`
+build:
+	foo: 
+		from: alpine:${LAYERCAKE_TEST_UNDEFINED}
+`)))
+
+		assert.Error(t, err)
+		assert.Nil(t, config)
+	})
+
 	t.Run("Error", func(t *testing.T) {
 		config, err := LoadConfig([]byte("build: 123"))
 
@@ -299,13 +1204,14 @@ build:
 
 		config, err := LoadConfigFile(file.Name())
 		require.NoError(t, err)
-		assert.Equal(t, &Config{
+		assertConfig(t, &Config{
 			Build: map[string]BuildConfig{
 				"foo": {
 					From: "alpine",
 					Tags: []string{"foo-alpine"},
 				},
 			},
+			files: []string{file.Name()},
 		}, config)
 	})
 
@@ -314,6 +1220,142 @@ build:
 		assert.Error(t, err)
 		assert.Nil(t, config)
 	})
+
+	t.Run("Include", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "layercake")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		writeFile := func(name, content string) string {
+			path := filepath.Join(dir, name)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+			require.NoError(t, ioutil.WriteFile(path, []byte(normalizeYAMLString(content)), os.ModePerm))
+			return path
+		}
+
+		t.Run("Success", func(t *testing.T) {
+			root := writeFile("layercake.yml", `
+include:
+	- services/*/layercake.yml
+	- common.yml
+variables:
+	VERSION: "2"
+build:
+	foo:
+		from: alpine
+`)
+			bar := writeFile("services/bar/layercake.yml", `
+include:
+	- ../../common.yml
+variables:
+	VERSION: "1"
+build:
+	bar:
+		from: alpine:${VERSION}
+`)
+			baz := writeFile("services/baz/layercake.yml", `
+build:
+	baz:
+		from: alpine
+`)
+			common := writeFile("common.yml", `
+include:
+	- layercake.yml
+build:
+	common:
+		from: alpine
+`)
+
+			config, err := LoadConfigFile(root)
+			require.NoError(t, err)
+			assertConfig(t, &Config{
+				Build: map[string]BuildConfig{
+					"foo":    {From: "alpine"},
+					"bar":    {From: "alpine:2"},
+					"baz":    {From: "alpine"},
+					"common": {From: "alpine"},
+				},
+				Variables: map[string]string{"VERSION": "2"},
+				files:     []string{root, bar, common, baz},
+			}, config)
+		})
+
+		t.Run("Duplicated build", func(t *testing.T) {
+			root := writeFile("duplicated.yml", `
+include:
+	- duplicated-foo.yml
+build:
+	foo:
+		from: alpine
+`)
+			foo := writeFile("duplicated-foo.yml", `
+build:
+	foo:
+		from: alpine
+`)
+
+			config, err := LoadConfigFile(root)
+			assert.EqualError(t, err, fmt.Sprintf(`%s:2:3: build "foo" is already defined at %s:4:3`, foo, root))
+			assert.Nil(t, config)
+		})
+
+		t.Run("Errors in multiple files", func(t *testing.T) {
+			root := writeFile("errors.yml", `
+include:
+	- errors-*.yml
+build:
+	foo:
+		from: alpine
+		tag: foo
+`)
+			syntax := writeFile("errors-a.yml", `
+build:
+	bar: [
+`)
+			unknown := writeFile("errors-b.yml", `
+build:
+	baz:
+		from: alpine
+		scripts: foo
+`)
+
+			config, err := LoadConfigFile(root)
+			assert.EqualError(t, err, fmt.Sprintf(`%s:2: did not find expected node content
+%s:4:14: expected a list
+%s:6:5: unknown field "tag"`, syntax, unknown, root))
+			assert.Nil(t, config)
+		})
+
+		t.Run("Errors in variables of included files", func(t *testing.T) {
+			root := writeFile("variables.yml", `
+include:
+	- variables-included.yml
+variables:
+	FOO: foo
+`)
+			included := writeFile("variables-included.yml", `
+variables:
+	BAR: bar
+	BAZ: ${LAYERCAKE_TEST_UNDEFINED}
+`)
+
+			config, err := LoadConfigFile(root)
+			assert.EqualError(t, err, fmt.Sprintf(`%s:3:8: variable "BAZ": undefined variable "LAYERCAKE_TEST_UNDEFINED"`, included))
+			assert.Nil(t, config)
+		})
+
+		t.Run("Not found", func(t *testing.T) {
+			root := writeFile("not-found.yml", `
+include:
+	- not-exist.yml
+`)
+
+			config, err := LoadConfigFile(root)
+			assert.Error(t, err)
+			assert.False(t, os.IsNotExist(err))
+			assert.Nil(t, config)
+		})
+	})
 }
 
 func TestInitConfig(t *testing.T) {
@@ -324,13 +1366,19 @@ build:
 		tags: 
 			- foo-alpine
 `))
-	expected := &Config{
-		Build: map[string]BuildConfig{
-			"foo": {
-				From: "alpine",
-				Tags: []string{"foo-alpine"},
+	expected := func(path string) *Config {
+		absPath, err := filepath.Abs(path)
+		require.NoError(t, err)
+
+		return &Config{
+			Build: map[string]BuildConfig{
+				"foo": {
+					From: "alpine",
+					Tags: []string{"foo-alpine"},
+				},
 			},
-		},
+			files: []string{absPath},
+		}
 	}
 
 	t.Run("Specified config path", func(t *testing.T) {
@@ -342,7 +1390,7 @@ build:
 			globalOptions.Config = file.Name()
 			actual, err := InitConfig()
 			require.NoError(t, err)
-			assert.Equal(t, expected, actual)
+			assertConfig(t, expected(file.Name()), actual)
 		})
 
 		t.Run("Not found", func(t *testing.T) {
@@ -364,7 +1412,7 @@ build:
 
 				actual, err := InitConfig()
 				require.NoError(t, err)
-				assert.Equal(t, expected, actual)
+				assertConfig(t, expected(path), actual)
 			})
 		}
 
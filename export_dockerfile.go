@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+const ruleImportLayers = "import-layers"
+
+// nolint: gochecknoglobals
+var dockerfileQuoteReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+
+type ExportDockerfileOptions struct {
+	Output string `long:"output" short:"o" description:"Path of the output Dockerfile. Print to stdout if not set." value-name:"PATH"`
+}
+
+func init() {
+	var exportDockerfileOptions ExportDockerfileOptions
+
+	_, err := parser.AddCommand("export-dockerfile", "Export builds as a multi-stage Dockerfile", "Render builds and all builds they depend on as stages of a Dockerfile. All builds are exported if build names are not given.", &exportDockerfileOptions)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (e *ExportDockerfileOptions) Execute(args []string) error {
+	config, err := InitConfig()
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	dockerfile, issues, err := ExportDockerfile(config, args)
+
+	if err != nil {
+		return err
+	}
+
+	for _, issue := range issues {
+		fmt.Fprintln(os.Stderr, issue)
+	}
+
+	if e.Output == "" {
+		fmt.Print(dockerfile)
+		return nil
+	}
+
+	if err := ioutil.WriteFile(e.Output, []byte(dockerfile), 0644); err != nil {
+		return merry.Wrap(err)
+	}
+
+	return nil
+}
+
+// ExportDockerfile renders the builds and all builds they depend on as stages
+// of a multi-stage Dockerfile. Stages are named after builds and imports are
+// converted into COPY --from=<stage>. Dependencies come first, so the last
+// stage is the default target. The experimental syntax is enabled if any RUN
+// instructions have mounts. Imports of layers which can't be selected in a
+// Dockerfile are reported. Builds whose names only differ in case can't be
+// exported because stage names are case-insensitive.
+func ExportDockerfile(config *Config, names []string) (string, []ValidationIssue, error) {
+	builds, err := config.SortBuildsWithDependencies(names)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	stageBuilds := map[string]string{}
+
+	for _, name := range builds {
+		stage := stageName(name)
+
+		if other, ok := stageBuilds[stage]; ok {
+			return "", nil, merry.Errorf("builds %q and %q have the same stage name %q", other, name, stage)
+		}
+
+		stageBuilds[stage] = name
+	}
+
+	var stages []string
+	var issues []ValidationIssue
+	var syntax string
+
+	for _, name := range builds {
+		stage, stageIssues, err := exportStage(name, config.Build[name])
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		stages = append(stages, stage)
+		issues = append(issues, stageIssues...)
+
+		for _, script := range config.Build[name].Scripts {
+			if script.hasMounts() {
+				syntax = "# syntax = " + dockerfileExperimentalSyntax + "\n"
+			}
+		}
+	}
+
+	return syntax + strings.Join(stages, "\n"), issues, nil
+}
+
+func exportStage(name string, build BuildConfig) (string, []ValidationIssue, error) {
+	var lines []string
+	var issues []ValidationIssue
+
+	if len(build.Tags) > 0 {
+		lines = append(lines, "# Tags: "+strings.Join(build.Tags, ", "))
+	}
+
+	lines = append(lines, fmt.Sprintf("FROM %s AS %s", build.From, stageName(name)))
+
+	for _, key := range sortedMapKeys(build.Args) {
+		value, err := dockerfileQuote(build.Args[key])
+
+		if err != nil {
+			return "", nil, merry.Prependf(err, "build %q: arg %q", name, key)
+		}
+
+		lines = append(lines, fmt.Sprintf("ARG %s=%s", key, value))
+	}
+
+	for _, script := range build.Scripts {
+		if script.Import == "" {
+			lines = append(lines, script.Dockerfile())
+			continue
+		}
+
+		importLines, issue := exportImport(script)
+		lines = append(lines, importLines...)
+
+		if issue != nil {
+			issues = append(issues, *issue)
+		}
+	}
+
+	for _, key := range sortedMapKeys(build.Labels) {
+		label, err := dockerfileQuote(key)
+
+		if err != nil {
+			return "", nil, merry.Prependf(err, "build %q: label", name)
+		}
+
+		value, err := dockerfileQuote(build.Labels[key])
+
+		if err != nil {
+			return "", nil, merry.Prependf(err, "build %q: label %q", name, key)
+		}
+
+		lines = append(lines, fmt.Sprintf("LABEL %s=%s", label, value))
+	}
+
+	return strings.Join(lines, "\n") + "\n", issues, nil
+}
+
+// exportImport converts the import into COPY instructions. Imported paths are
+// copied as is. Layers can't be selected in a Dockerfile, so the whole file
+// system is copied if paths are not specified, and an issue is returned unless
+// all layers are imported.
+func exportImport(script BuildScript) ([]string, *ValidationIssue) {
+	var lines []string
+	options := script.ImportOptions
+	stage := stageName(script.Import)
+
+	if len(options.Paths) == 0 {
+		lines = append(lines, fmt.Sprintf("COPY --from=%s / %s", stage, exportImportDest(options.To, "/")))
+	}
+
+	for _, p := range options.Paths {
+		lines = append(lines, fmt.Sprintf("COPY --from=%s %s %s", stage, p, exportImportDest(options.To, p)))
+	}
+
+	if options.LayerSet() == LayerSetAll {
+		return lines, nil
+	}
+
+	issue := newValidationIssue(SeverityWarning, ruleImportLayers, script.pos,
+		"import of %q uses layers %q, which can't be selected in a Dockerfile, so files are copied from the whole file system", script.Import, options.LayerSet())
+
+	return lines, &issue
+}
+
+// exportImportDest returns the destination of the imported path. Files are
+// copied into the destination with their base names, which is different from
+// COPY copying contents of directories.
+func exportImportDest(to, p string) string {
+	name := normalizeTarName(p)
+
+	if to == "" {
+		return "/" + name
+	}
+
+	if name == "" {
+		return to
+	}
+
+	return path.Join(to, path.Base(name))
+}
+
+// stageName returns the name of the build in lower case because stage names
+// are case-insensitive.
+func stageName(name string) string {
+	return strings.ToLower(name)
+}
+
+// dockerfileQuote returns the value in double quotes, in which backslashes,
+// double quotes and dollar signs are escaped as in Dockerfiles. Newlines can't
+// be written in Dockerfiles.
+func dockerfileQuote(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return "", merry.Errorf("newlines can't be written in %q", s)
+	}
+
+	return `"` + dockerfileQuoteReplacer.Replace(s) + `"`, nil
+}
+
+func sortedMapKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportDockerfile(t *testing.T) {
+	config, err := LoadConfig([]byte(`
+build:
+  app:
+    from: alpine
+    tags:
+      - app:latest
+    args:
+      VERSION: "1.0"
+    labels:
+      maintainer: foo
+    scripts:
+      - import: builder
+      - import:
+          from: builder
+          paths:
+            - /usr/local/bin/hello
+            - etc/hello
+          to: /usr/bin
+      - cmd: hello
+  builder:
+    from: golang:alpine
+    scripts:
+      - run: go build -o /usr/local/bin/hello .
+  Tools:
+    from: alpine
+    scripts:
+      - import:
+          from: builder
+          layers: all
+`))
+
+	require.NoError(t, err)
+
+	t.Run("All builds", func(t *testing.T) {
+		dockerfile, issues, err := ExportDockerfile(config, nil)
+
+		require.NoError(t, err)
+		assert.Equal(t, `FROM golang:alpine AS builder
+RUN go build -o /usr/local/bin/hello .
+
+FROM alpine AS tools
+COPY --from=builder / /
+
+# Tags: app:latest
+FROM alpine AS app
+ARG VERSION="1.0"
+COPY --from=builder / /
+COPY --from=builder /usr/local/bin/hello /usr/bin/hello
+COPY --from=builder etc/hello /usr/bin/hello
+CMD hello
+LABEL "maintainer"="foo"
+`, dockerfile)
+
+		require.Len(t, issues, 1)
+		assert.Equal(t, ruleImportLayers, issues[0].Rule)
+		assert.Equal(t, `import of "builder" uses layers "last", which can't be selected in a Dockerfile, so files are copied from the whole file system`, issues[0].Message)
+		assert.Equal(t, 12, issues[0].Line)
+	})
+
+	t.Run("Selected builds", func(t *testing.T) {
+		dockerfile, _, err := ExportDockerfile(config, []string{"Tools"})
+
+		require.NoError(t, err)
+		assert.Equal(t, `FROM golang:alpine AS builder
+RUN go build -o /usr/local/bin/hello .
+
+FROM alpine AS tools
+COPY --from=builder / /
+`, dockerfile)
+	})
+
+	t.Run("Mounts", func(t *testing.T) {
+		config, err := LoadConfig([]byte(`
+build:
+  app:
+    from: golang
+    secrets: [netrc]
+    scripts:
+      - run: go mod download
+        mount: type=secret,id=netrc,target=/root/.netrc
+`))
+		require.NoError(t, err)
+
+		dockerfile, _, err := ExportDockerfile(config, nil)
+
+		require.NoError(t, err)
+		assert.Equal(t, `# syntax = docker/dockerfile:1.0-experimental
+FROM golang AS app
+RUN --mount=type=secret,id=netrc,target=/root/.netrc go mod download
+`, dockerfile)
+	})
+
+	t.Run("Escape", func(t *testing.T) {
+		config, err := LoadConfig([]byte(`
+build:
+  app:
+    from: alpine
+    args:
+      VALUE: 'a "b" \c $d é'
+    labels:
+      "com.example.\"key\"": $$foo
+`))
+		require.NoError(t, err)
+
+		dockerfile, _, err := ExportDockerfile(config, nil)
+
+		require.NoError(t, err)
+		assert.Equal(t, `FROM alpine AS app
+ARG VALUE="a \"b\" \\c \$d é"
+LABEL "com.example.\"key\""="\$foo"
+`, dockerfile)
+	})
+
+	t.Run("Newlines", func(t *testing.T) {
+		config, err := LoadConfig([]byte(`
+build:
+  app:
+    from: alpine
+    labels:
+      description: "foo\nbar"
+`))
+		require.NoError(t, err)
+
+		_, _, err = ExportDockerfile(config, nil)
+		assert.EqualError(t, err, `build "app": label "description": newlines can't be written in "foo\nbar"`)
+	})
+
+	t.Run("Stage name collision", func(t *testing.T) {
+		config, err := LoadConfig([]byte(`
+build:
+  App:
+    from: alpine
+  app:
+    from: alpine
+`))
+		require.NoError(t, err)
+
+		_, _, err = ExportDockerfile(config, nil)
+		assert.EqualError(t, err, `builds "App" and "app" have the same stage name "app"`)
+	})
+
+	t.Run("Undefined build", func(t *testing.T) {
+		_, _, err := ExportDockerfile(config, []string{"foo"})
+		assert.EqualError(t, err, `build "foo" is not defined`)
+	})
+}
+
+func TestExportImportDest(t *testing.T) {
+	tests := []struct {
+		To       string
+		Path     string
+		Expected string
+	}{
+		{To: "", Path: "/usr/bin/foo", Expected: "/usr/bin/foo"},
+		{To: "", Path: "usr/bin/foo", Expected: "/usr/bin/foo"},
+		{To: "", Path: "/", Expected: "/"},
+		{To: "/app", Path: "/usr/bin/foo/", Expected: "/app/foo"},
+		{To: "/app", Path: "/", Expected: "/app"},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.To+":"+test.Path, func(t *testing.T) {
+			assert.Equal(t, test.Expected, exportImportDest(test.To, test.Path))
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ListMergeAppend  = "append"
+	ListMergePrepend = "prepend"
+	ListMergeReplace = "replace"
+)
+
+// nolint: gochecknoglobals
+var listMergeModes = []string{ListMergeAppend, ListMergePrepend, ListMergeReplace}
+
+// BuildExtends defines the build or the template a build inherits from, and
+// how lists are merged. Lists are appended to lists of the parent by default.
+type BuildExtends struct {
+	Name      string `yaml:"name,omitempty"`
+	Scripts   string `yaml:"scripts,omitempty"`
+	CacheFrom string `yaml:"cache_from,omitempty"`
+	Inputs    string `yaml:"inputs,omitempty"`
+}
+
+func (e *BuildExtends) UnmarshalYAML(node *yaml.Node) error {
+	if node.ShortTag() == "!!str" {
+		e.Name = node.Value
+		return nil
+	}
+
+	type rawBuildExtends BuildExtends
+	var raw rawBuildExtends
+
+	if err := decodeYAML("", node, &raw); err != nil {
+		return err
+	}
+
+	if raw.Name == "" {
+		return errors.New("extends must have a name")
+	}
+
+	for _, mode := range []string{raw.Scripts, raw.CacheFrom, raw.Inputs} {
+		if mode != "" && !stringSliceContains(listMergeModes, mode) {
+			return fmt.Errorf("merge mode should be one of %s", strings.Join(listMergeModes, ", "))
+		}
+	}
+
+	*e = BuildExtends(raw)
+	return nil
+}
+
+// MarshalYAML returns the name only if all lists use the default merge mode.
+func (e BuildExtends) MarshalYAML() (interface{}, error) {
+	if e.Scripts == "" && e.CacheFrom == "" && e.Inputs == "" {
+		return e.Name, nil
+	}
+
+	type rawBuildExtends BuildExtends
+	return rawBuildExtends(e), nil
+}
+
+// Merge returns the build inheriting fields from the parent. Maps are merged,
+// lists are merged according to their merge modes and other fields are
+// overridden if they are set in the child. Tags are not inherited.
+func (e BuildExtends) Merge(parent, child BuildConfig) BuildConfig {
+	result := child
+	result.Extends = BuildExtends{}
+
+	if result.From == "" {
+		result.From = parent.From
+	}
+
+	if result.Matrix == nil {
+		result.Matrix = parent.Matrix
+	}
+
+	if result.Platforms == nil {
+		result.Platforms = parent.Platforms
+	}
+
+	result.Args = mergeStringMap(parent.Args, child.Args)
+	result.Labels = mergeStringMap(parent.Labels, child.Labels)
+	result.CacheFrom = mergeStringSlice(e.CacheFrom, parent.CacheFrom, child.CacheFrom)
+	result.Inputs = mergeStringSlice(e.Inputs, parent.Inputs, child.Inputs)
+
+	// Secrets are always appended because inherited scripts may mount them
+	result.Secrets = mergeStringSlice(ListMergeAppend, parent.Secrets, child.Secrets)
+
+	switch e.Scripts {
+	case ListMergePrepend:
+		result.Scripts = append(append([]BuildScript{}, child.Scripts...), parent.Scripts...)
+
+	case ListMergeReplace:
+		if len(child.Scripts) == 0 {
+			result.Scripts = parent.Scripts
+		}
+
+	default:
+		result.Scripts = append(append([]BuildScript{}, parent.Scripts...), child.Scripts...)
+	}
+
+	return result
+}
+
+func mergeStringMap(parent, child map[string]string) map[string]string {
+	if parent == nil {
+		return child
+	}
+
+	result := map[string]string{}
+
+	for k, v := range parent {
+		result[k] = v
+	}
+
+	for k, v := range child {
+		result[k] = v
+	}
+
+	return result
+}
+
+func mergeStringSlice(mode string, parent, child []string) []string {
+	switch mode {
+	case ListMergePrepend:
+		return append(append([]string{}, child...), parent...)
+
+	case ListMergeReplace:
+		if len(child) == 0 {
+			return parent
+		}
+
+		return child
+
+	default:
+		if parent == nil {
+			return child
+		}
+
+		return append(append([]string{}, parent...), child...)
+	}
+}
+
+// findExtendable returns the build or the template of the name.
+func (c *Config) findExtendable(name string) (BuildConfig, bool) {
+	if build, ok := c.Build[name]; ok {
+		return build, true
+	}
+
+	build, ok := c.Templates[name]
+	return build, ok
+}
+
+// FindExtendsCycle returns the first inheritance cycle found in builds and
+// templates, starting and ending with the same name. It returns nil if there
+// are no cycles.
+func (c *Config) FindExtendsCycle() []string {
+	names := append(c.BuildNames(), sortedBuildNames(c.Templates)...)
+
+	for _, name := range names {
+		var chain []string
+		current := name
+
+		for current != "" {
+			if idx := stringSliceIndex(chain, current); idx >= 0 {
+				return append(chain[idx:], current)
+			}
+
+			chain = append(chain, current)
+			build, ok := c.findExtendable(current)
+
+			if !ok {
+				break
+			}
+
+			current = build.Extends.Name
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateExtends() (errs ConfigErrors) {
+	for _, name := range sortedBuildNames(c.Templates) {
+		if _, ok := c.Build[name]; ok {
+			errs = append(errs, newConfigError(c.Templates[name].position(""), "template %q conflicts with the build of the same name", name))
+		}
+	}
+
+	for _, names := range [][]string{c.BuildNames(), sortedBuildNames(c.Templates)} {
+		for _, name := range names {
+			build, _ := c.findExtendable(name)
+
+			if parent := build.Extends.Name; parent != "" {
+				if _, ok := c.findExtendable(parent); !ok {
+					errs = append(errs, newConfigError(build.position("extends"), "build %q extends undefined build %q", name, parent))
+				}
+			}
+		}
+	}
+
+	if cycle := c.FindExtendsCycle(); cycle != nil {
+		build, _ := c.findExtendable(cycle[0])
+		errs = append(errs, newConfigError(build.position("extends"), "extends cycle detected: %s", strings.Join(cycle, " -> ")))
+	}
+
+	return
+}
+
+// ResolveExtends merges builds and templates with their parents.
+func (c *Config) ResolveExtends() error {
+	if err := c.validateExtends().Err(); err != nil {
+		return err
+	}
+
+	resolved := map[string]BuildConfig{}
+
+	var resolve func(name string) BuildConfig
+
+	resolve = func(name string) BuildConfig {
+		if build, ok := resolved[name]; ok {
+			return build
+		}
+
+		build, _ := c.findExtendable(name)
+
+		if parent := build.Extends.Name; parent != "" {
+			build = build.Extends.Merge(resolve(parent), build)
+		}
+
+		resolved[name] = build
+		return build
+	}
+
+	for _, name := range sortedBuildNames(c.Templates) {
+		c.Templates[name] = resolve(name)
+	}
+
+	for _, name := range c.BuildNames() {
+		c.Build[name] = resolve(name)
+	}
+
+	return nil
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestBuildExtends_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Input    string
+		Expected BuildExtends
+		Error    bool
+	}{
+		{
+			Name:     "String",
+			Input:    "foo",
+			Expected: BuildExtends{Name: "foo"},
+		},
+		{
+			Name: "Map",
+			Input: normalizeYAMLString(`
+name: foo
+scripts: prepend
+cache_from: replace
+inputs: append
+`),
+			Expected: BuildExtends{
+				Name:      "foo",
+				Scripts:   ListMergePrepend,
+				CacheFrom: ListMergeReplace,
+				Inputs:    ListMergeAppend,
+			},
+		},
+		{
+			Name:  "Without name",
+			Input: "scripts: prepend",
+			Error: true,
+		},
+		{
+			Name: "Invalid merge mode",
+			Input: normalizeYAMLString(`
+name: foo
+scripts: foo
+`),
+			Error: true,
+		},
+		{
+			Name: "Unknown field",
+			Input: normalizeYAMLString(`
+name: foo
+tags: append
+`),
+			Error: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			var actual BuildExtends
+			err := yaml.Unmarshal([]byte(test.Input), &actual)
+
+			if test.Error {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, test.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestBuildExtends_MarshalYAML(t *testing.T) {
+	t.Run("Name only", func(t *testing.T) {
+		data, err := yaml.Marshal(BuildExtends{Name: "foo"})
+		require.NoError(t, err)
+		assert.Equal(t, "foo\n", string(data))
+	})
+
+	t.Run("Merge modes", func(t *testing.T) {
+		data, err := yaml.Marshal(BuildExtends{Name: "foo", Scripts: ListMergeReplace})
+		require.NoError(t, err)
+		assert.Equal(t, "name: foo\nscripts: replace\n", string(data))
+	})
+}
+
+func TestBuildExtends_Merge(t *testing.T) {
+	parent := BuildConfig{
+		From:      "alpine",
+		Tags:      []string{"parent"},
+		Args:      map[string]string{"a": "1", "b": "2"},
+		Labels:    map[string]string{"a": "1"},
+		Scripts:   []BuildScript{{Raw: "RUN parent"}},
+		CacheFrom: []string{"parent"},
+		Inputs:    []string{"parent"},
+		Platforms: []string{"linux/amd64"},
+	}
+
+	tests := []struct {
+		Name     string
+		Extends  BuildExtends
+		Child    BuildConfig
+		Expected BuildConfig
+	}{
+		{
+			Name:    "Empty child",
+			Extends: BuildExtends{Name: "parent"},
+			Expected: BuildConfig{
+				From:      "alpine",
+				Args:      map[string]string{"a": "1", "b": "2"},
+				Labels:    map[string]string{"a": "1"},
+				Scripts:   []BuildScript{{Raw: "RUN parent"}},
+				CacheFrom: []string{"parent"},
+				Inputs:    []string{"parent"},
+				Platforms: []string{"linux/amd64"},
+			},
+		},
+		{
+			Name:    "Append",
+			Extends: BuildExtends{Name: "parent"},
+			Child: BuildConfig{
+				From:      "busybox",
+				Tags:      []string{"child"},
+				Args:      map[string]string{"b": "3"},
+				Scripts:   []BuildScript{{Raw: "RUN child"}},
+				CacheFrom: []string{"child"},
+				Inputs:    []string{"child"},
+				Platforms: []string{"linux/arm64"},
+			},
+			Expected: BuildConfig{
+				From:      "busybox",
+				Tags:      []string{"child"},
+				Args:      map[string]string{"a": "1", "b": "3"},
+				Labels:    map[string]string{"a": "1"},
+				Scripts:   []BuildScript{{Raw: "RUN parent"}, {Raw: "RUN child"}},
+				CacheFrom: []string{"parent", "child"},
+				Inputs:    []string{"parent", "child"},
+				Platforms: []string{"linux/arm64"},
+			},
+		},
+		{
+			Name: "Prepend",
+			Extends: BuildExtends{
+				Name:      "parent",
+				Scripts:   ListMergePrepend,
+				CacheFrom: ListMergePrepend,
+				Inputs:    ListMergePrepend,
+			},
+			Child: BuildConfig{
+				Scripts:   []BuildScript{{Raw: "RUN child"}},
+				CacheFrom: []string{"child"},
+				Inputs:    []string{"child"},
+			},
+			Expected: BuildConfig{
+				From:      "alpine",
+				Args:      map[string]string{"a": "1", "b": "2"},
+				Labels:    map[string]string{"a": "1"},
+				Scripts:   []BuildScript{{Raw: "RUN child"}, {Raw: "RUN parent"}},
+				CacheFrom: []string{"child", "parent"},
+				Inputs:    []string{"child", "parent"},
+				Platforms: []string{"linux/amd64"},
+			},
+		},
+		{
+			Name: "Replace",
+			Extends: BuildExtends{
+				Name:      "parent",
+				Scripts:   ListMergeReplace,
+				CacheFrom: ListMergeReplace,
+				Inputs:    ListMergeReplace,
+			},
+			Child: BuildConfig{
+				Scripts:   []BuildScript{{Raw: "RUN child"}},
+				CacheFrom: []string{"child"},
+			},
+			Expected: BuildConfig{
+				From:      "alpine",
+				Args:      map[string]string{"a": "1", "b": "2"},
+				Labels:    map[string]string{"a": "1"},
+				Scripts:   []BuildScript{{Raw: "RUN child"}},
+				CacheFrom: []string{"child"},
+				Inputs:    []string{"parent"},
+				Platforms: []string{"linux/amd64"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			test.Child.Extends = test.Extends
+			assert.Equal(t, test.Expected, test.Extends.Merge(parent, test.Child))
+		})
+	}
+
+	t.Run("Secrets", func(t *testing.T) {
+		parent := BuildConfig{Secrets: []string{"npmrc"}}
+		child := BuildConfig{Secrets: []string{"netrc"}}
+
+		for _, mode := range listMergeModes {
+			result := BuildExtends{Name: "parent", Scripts: mode}.Merge(parent, child)
+			assert.Equal(t, []string{"npmrc", "netrc"}, result.Secrets)
+		}
+	})
+
+	t.Run("Parent is not modified", func(t *testing.T) {
+		child := BuildConfig{Args: map[string]string{"a": "2"}}
+		BuildExtends{Name: "parent"}.Merge(parent, child)
+		assert.Equal(t, "1", parent.Args["a"])
+	})
+}
+
+func TestConfig_FindExtendsCycle(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Config   Config
+		Expected []string
+	}{
+		{
+			Name: "No cycles",
+			Config: Config{
+				Build: map[string]BuildConfig{
+					"a": {Extends: BuildExtends{Name: "b"}},
+					"b": {Extends: BuildExtends{Name: "c"}},
+				},
+				Templates: map[string]BuildConfig{
+					"c": {},
+				},
+			},
+		},
+		{
+			Name: "Cycle",
+			Config: Config{
+				Build: map[string]BuildConfig{
+					"a": {Extends: BuildExtends{Name: "b"}},
+				},
+				Templates: map[string]BuildConfig{
+					"b": {Extends: BuildExtends{Name: "c"}},
+					"c": {Extends: BuildExtends{Name: "b"}},
+				},
+			},
+			Expected: []string{"b", "c", "b"},
+		},
+		{
+			Name: "Self",
+			Config: Config{
+				Build: map[string]BuildConfig{
+					"a": {Extends: BuildExtends{Name: "a"}},
+				},
+			},
+			Expected: []string{"a", "a"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, test.Config.FindExtendsCycle())
+		})
+	}
+}
+
+func TestConfig_ResolveExtends(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		config := &Config{
+			Templates: map[string]BuildConfig{
+				"base": {
+					From:    "debian",
+					Scripts: []BuildScript{{Raw: "RUN apt-get update"}},
+				},
+				"tools": {
+					Extends: BuildExtends{Name: "base"},
+					Scripts: []BuildScript{{Raw: "RUN apt-get install curl"}},
+				},
+			},
+			Build: map[string]BuildConfig{
+				"foo": {
+					Extends: BuildExtends{Name: "tools"},
+					Tags:    []string{"foo"},
+					Scripts: []BuildScript{{Raw: "RUN foo"}},
+				},
+				"bar": {
+					Extends: BuildExtends{Name: "foo"},
+					Scripts: []BuildScript{{Raw: "RUN bar"}},
+				},
+			},
+		}
+
+		require.NoError(t, config.ResolveExtends())
+		assert.Equal(t, BuildConfig{
+			From: "debian",
+			Tags: []string{"foo"},
+			Scripts: []BuildScript{
+				{Raw: "RUN apt-get update"},
+				{Raw: "RUN apt-get install curl"},
+				{Raw: "RUN foo"},
+			},
+		}, config.Build["foo"])
+		assert.Equal(t, BuildConfig{
+			From: "debian",
+			Scripts: []BuildScript{
+				{Raw: "RUN apt-get update"},
+				{Raw: "RUN apt-get install curl"},
+				{Raw: "RUN foo"},
+				{Raw: "RUN bar"},
+			},
+		}, config.Build["bar"])
+	})
+
+	t.Run("Undefined", func(t *testing.T) {
+		config := &Config{
+			Build: map[string]BuildConfig{
+				"foo": {Extends: BuildExtends{Name: "bar"}},
+			},
+		}
+
+		assert.EqualError(t, config.ResolveExtends(), `build "foo" extends undefined build "bar"`)
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		config := &Config{
+			Build: map[string]BuildConfig{
+				"foo": {Extends: BuildExtends{Name: "bar"}},
+				"bar": {Extends: BuildExtends{Name: "foo"}},
+			},
+		}
+
+		assert.EqualError(t, config.ResolveExtends(), "extends cycle detected: bar -> foo -> bar")
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		config := &Config{
+			Build: map[string]BuildConfig{
+				"foo": {From: "alpine"},
+			},
+			Templates: map[string]BuildConfig{
+				"foo": {From: "alpine"},
+			},
+		}
+
+		assert.EqualError(t, config.ResolveExtends(), `template "foo" conflicts with the build of the same name`)
+	})
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/ansel1/merry"
+)
+
+// fakeBackend is an in-memory backend for tests. Each build adds a layer on
+// top of its base image, which must be added with addImage first. Image IDs
+// are digests of their contents, so identical builds return the same ID.
+type fakeBackend struct {
+	// Files in the layer added by the build. The layer contains a file named
+	// after the build by default.
+	Files func(build *fakeBuild) []tarFile
+
+	// Errors returned by builds by their names
+	Errors map[string]error
+
+	lock          sync.Mutex
+	images        map[string]*fakeImage
+	builds        []*fakeBuild
+	saved         []string
+	pushed        []string
+	manifestLists map[string][]ManifestDescriptor
+}
+
+type fakeImage struct {
+	ID       string
+	Platform string
+	Layers   [][]byte
+	History  []imageHistory
+}
+
+// fakeBuild records a build request.
+type fakeBuild struct {
+	Name       string
+	Dockerfile string
+	Platform   string
+	Args       map[string]*string
+	Tags       []string
+	Secrets    map[string]string
+	ImageID    string
+
+	// Files in imported layers by their file names in the build context
+	Imports map[string][]tarFile
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		Errors:        map[string]error{},
+		images:        map[string]*fakeImage{},
+		manifestLists: map[string][]ManifestDescriptor{},
+	}
+}
+
+// addImage adds an image with the layers to the backend. The image is used for
+// all platforms.
+func (f *fakeBackend) addImage(name string, layers ...[]tarFile) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	image := &fakeImage{}
+
+	for _, files := range layers {
+		layer, err := writeFakeTar(files)
+
+		if err != nil {
+			return err
+		}
+
+		image.Layers = append(image.Layers, layer)
+		image.History = append(image.History, imageHistory{CreatedBy: fmt.Sprintf("ADD layer%d.tar /", len(image.Layers))})
+	}
+
+	image.ID = fakeImageID(append([][]byte{[]byte(name)}, image.Layers...)...)
+	f.images[name] = image
+	f.images[image.ID] = image
+	return nil
+}
+
+// Builds returns recorded builds in the order they are started.
+func (f *fakeBackend) Builds() []*fakeBuild {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]*fakeBuild{}, f.builds...)
+}
+
+// Saved returns names of saved images.
+func (f *fakeBackend) Saved() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]string{}, f.saved...)
+}
+
+// Pushed returns pushed tags.
+func (f *fakeBackend) Pushed() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]string{}, f.pushed...)
+}
+
+// ManifestLists returns pushed manifest lists by their tags.
+func (f *fakeBackend) ManifestLists() map[string][]ManifestDescriptor {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	result := map[string][]ManifestDescriptor{}
+
+	for k, v := range f.manifestLists {
+		result[k] = v
+	}
+
+	return result
+}
+
+func (f *fakeBackend) Build(ctx context.Context, req *BuildRequest) (string, error) {
+	imports := map[string][]tarFile{}
+
+	for name, src := range req.Imports {
+		file, err := os.Open(src)
+
+		if err != nil {
+			return "", err
+		}
+
+		files, err := readTar(file)
+		file.Close()
+
+		if err != nil {
+			return "", err
+		}
+
+		imports[name] = files
+	}
+
+	return f.build(&fakeBuild{
+		Name:       req.Name,
+		Dockerfile: string(req.Dockerfile),
+		Platform:   req.Platform,
+		Args:       req.Args,
+		Tags:       req.Tags,
+		Secrets:    req.Secrets,
+		Imports:    imports,
+	})
+}
+
+// build records the build and adds a layer on top of the base image in the
+// Dockerfile. The image is for the platform of the build, or the platform of
+// the base image if the build has no platform. LABEL instructions are added to
+// the history before the layer.
+func (f *fakeBackend) build(build *fakeBuild) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.builds = append(f.builds, build)
+
+	if err := f.Errors[build.Name]; err != nil {
+		return "", err
+	}
+
+	var from string
+	var labels []imageHistory
+
+	// Skip parser directives before FROM
+	for _, line := range strings.Split(build.Dockerfile, "\n") {
+		fields := strings.Fields(line)
+
+		switch {
+		case len(fields) == 0:
+		case from == "" && strings.EqualFold(fields[0], "FROM"):
+			from = fields[len(fields)-1]
+		case from != "" && strings.EqualFold(fields[0], "LABEL"):
+			labels = append(labels, imageHistory{CreatedBy: line, EmptyLayer: true})
+		}
+	}
+
+	base, ok := f.images[from]
+
+	if !ok {
+		return "", merry.Errorf("image %q not found", from)
+	}
+
+	files := []tarFile{{Name: build.Name, Data: []byte(build.Name)}}
+
+	if f.Files != nil {
+		files = f.Files(build)
+	}
+
+	layer, err := writeFakeTar(files)
+
+	if err != nil {
+		return "", err
+	}
+
+	platform := build.Platform
+
+	if platform == "" {
+		platform = base.Platform
+	}
+
+	image := &fakeImage{
+		ID:       fakeImageID([]byte(base.ID), []byte(platform), layer),
+		Platform: platform,
+		Layers:   append(append([][]byte{}, base.Layers...), layer),
+		History:  append(append(append([]imageHistory{}, base.History...), labels...), imageHistory{CreatedBy: "RUN " + build.Name}),
+	}
+
+	f.images[image.ID] = image
+
+	for _, tag := range build.Tags {
+		f.images[tag] = image
+	}
+
+	build.ImageID = image.ID
+	return image.ID, nil
+}
+
+// Save returns the image in the format of docker save, which contains the
+// manifest, the config named after the image ID and layers in directories
+// named after their indexes.
+func (f *fakeBackend) Save(ctx context.Context, name string) (io.ReadCloser, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	image, ok := f.images[name]
+
+	if !ok {
+		return nil, merry.Errorf("image %q not found", name)
+	}
+
+	f.saved = append(f.saved, name)
+
+	var files []tarFile
+	manifest := imageManifest{
+		Config: strings.TrimPrefix(image.ID, "sha256:") + ".json",
+	}
+
+	config := imageConfig{History: image.History}
+
+	if image.Platform != "" {
+		p, err := ParsePlatform(image.Platform)
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.OS, config.Architecture, config.Variant = p.OS, p.Architecture, p.Variant
+	}
+
+	data, err := json.Marshal(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	files = append(files, tarFile{Name: manifest.Config, Data: data})
+
+	for i, layer := range image.Layers {
+		name := path.Join(fmt.Sprintf("%d", i), "layer.tar")
+		manifest.Layers = append(manifest.Layers, name)
+		files = append(files, tarFile{Name: name, Data: layer})
+	}
+
+	if data, err = json.Marshal([]imageManifest{manifest}); err != nil {
+		return nil, err
+	}
+
+	files = append(files, tarFile{Name: "manifest.json", Data: data})
+	result, err := writeFakeTar(files)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(result)), nil
+}
+
+// Push returns a fake manifest of the image.
+func (f *fakeBackend) Push(ctx context.Context, tag string, out io.Writer) (*PushResult, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	image, ok := f.images[tag]
+
+	if !ok {
+		return nil, merry.Errorf("image %q not found", tag)
+	}
+
+	f.pushed = append(f.pushed, tag)
+
+	return &PushResult{
+		Digest: fakeImageID([]byte("manifest"), []byte(image.ID)),
+		Size:   int64(len(image.Layers)),
+	}, nil
+}
+
+func (f *fakeBackend) PushManifestList(ctx context.Context, tag string, manifests []ManifestDescriptor) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.manifestLists[tag] = manifests
+	return nil
+}
+
+func fakeImageID(data ...[]byte) string {
+	h := sha256.New()
+
+	for _, b := range data {
+		h.Write(b)
+	}
+
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
+
+func writeFakeTar(files []tarFile) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for _, file := range files {
+		header := &tar.Header{
+			Name: file.Name,
+			Size: int64(len(file.Data)),
+			Mode: 0644,
+		}
+
+		if _, err := TarAddFile(tw, header, bytes.NewReader(file.Data)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
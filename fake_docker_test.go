@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const fakeDockerAPIVersion = "1.38"
+
+// nolint: gochecknoglobals
+var fakeDockerVersionPath = regexp.MustCompile(`^/v([0-9.]+)(/.*)$`)
+
+// fakeDockerServer implements the subset of the Docker Engine API used by
+// layercake. Images are stored in Backend, and builds are named after their
+// first tags.
+type fakeDockerServer struct {
+	*httptest.Server
+	Backend *fakeBackend
+
+	// Error message of all builds in the build stream
+	BuildError string
+
+	// SaveFilter modifies files in saved images
+	SaveFilter func(files []tarFile) []tarFile
+
+	// SaveBroken aborts the connection in the middle of saved images
+	SaveBroken bool
+
+	lock     sync.Mutex
+	builds   []*fakeDockerBuild
+	versions []string
+}
+
+// fakeDockerBuild records a request of the build API.
+type fakeDockerBuild struct {
+	Query   url.Values
+	Context []tarFile
+}
+
+// Dockerfile returns the content of the Dockerfile in the build context.
+func (b *fakeDockerBuild) Dockerfile() string {
+	name := b.Query.Get("dockerfile")
+
+	if name == "" {
+		name = "Dockerfile"
+	}
+
+	return string(b.File(name))
+}
+
+// File returns the content of the file in the build context, or nil if the
+// file doesn't exist.
+func (b *fakeDockerBuild) File(name string) []byte {
+	for _, file := range b.Context {
+		if file.Name == name {
+			return file.Data
+		}
+	}
+
+	return nil
+}
+
+// startFakeDockerServer starts the server and points DOCKER_HOST to it. The
+// returned function stops the server and restores DOCKER_HOST.
+func startFakeDockerServer() (*fakeDockerServer, func()) {
+	s := &fakeDockerServer{Backend: newFakeBackend()}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	host, hasHost := os.LookupEnv("DOCKER_HOST")
+	os.Setenv("DOCKER_HOST", "tcp://"+s.Listener.Addr().String())
+
+	return s, func() {
+		if hasHost {
+			os.Setenv("DOCKER_HOST", host)
+		} else {
+			os.Unsetenv("DOCKER_HOST")
+		}
+
+		s.Close()
+	}
+}
+
+// Builds returns requests of the build API.
+func (s *fakeDockerServer) Builds() []*fakeDockerBuild {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]*fakeDockerBuild{}, s.builds...)
+}
+
+// Versions returns API versions of requests. Requests without versions are
+// skipped.
+func (s *fakeDockerServer) Versions() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]string{}, s.versions...)
+}
+
+func (s *fakeDockerServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Path
+
+	if m := fakeDockerVersionPath.FindStringSubmatch(p); m != nil {
+		s.lock.Lock()
+		s.versions = append(s.versions, m[1])
+		s.lock.Unlock()
+
+		p = m[2]
+	}
+
+	switch {
+	case p == "/_ping":
+		s.ping(w)
+
+	case p == "/build" && r.Method == http.MethodPost:
+		s.build(w, r)
+
+	case p == "/images/get" && r.Method == http.MethodGet:
+		s.save(w, r)
+
+	default:
+		writeFakeDockerError(w, http.StatusNotFound, fmt.Sprintf("page not found: %s %s", r.Method, p))
+	}
+}
+
+func (s *fakeDockerServer) ping(w http.ResponseWriter) {
+	w.Header().Set("API-Version", fakeDockerAPIVersion)
+	w.Header().Set("OSType", "linux")
+	w.Header().Set("Docker-Experimental", "false")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "OK")
+}
+
+func (s *fakeDockerServer) build(w http.ResponseWriter, r *http.Request) {
+	files, err := readTar(r.Body)
+
+	if err != nil {
+		writeFakeDockerError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	req := &fakeDockerBuild{
+		Query:   r.URL.Query(),
+		Context: files,
+	}
+
+	s.lock.Lock()
+	s.builds = append(s.builds, req)
+	s.lock.Unlock()
+
+	build := &fakeBuild{
+		Dockerfile: req.Dockerfile(),
+		Platform:   req.Query.Get("platform"),
+		Args:       map[string]*string{},
+		Tags:       req.Query["t"],
+		Imports:    map[string][]tarFile{},
+	}
+
+	if len(build.Tags) > 0 {
+		build.Name = build.Tags[0]
+	}
+
+	if v := req.Query.Get("buildargs"); v != "" {
+		if err := json.Unmarshal([]byte(v), &build.Args); err != nil {
+			writeFakeDockerError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	for _, file := range files {
+		if path.Dir(file.Name) != layercakeBaseDir || path.Ext(file.Name) != ".tar" {
+			continue
+		}
+
+		layer, err := readTar(bytes.NewReader(file.Data))
+
+		if err != nil {
+			writeFakeDockerError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		build.Imports[path.Base(file.Name)] = layer
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	encoder.Encode(map[string]string{"stream": "Step 1/1 : " + strings.SplitN(build.Dockerfile, "\n", 2)[0] + "\n"})
+
+	if s.BuildError != "" {
+		encoder.Encode(map[string]interface{}{
+			"errorDetail": map[string]string{"message": s.BuildError},
+			"error":       s.BuildError,
+		})
+
+		return
+	}
+
+	id, err := s.Backend.build(build)
+
+	if err != nil {
+		encoder.Encode(map[string]interface{}{
+			"errorDetail": map[string]string{"message": err.Error()},
+			"error":       err.Error(),
+		})
+
+		return
+	}
+
+	encoder.Encode(map[string]interface{}{"aux": map[string]string{"ID": id}})
+	encoder.Encode(map[string]string{"stream": "Successfully built " + id + "\n"})
+}
+
+func (s *fakeDockerServer) save(w http.ResponseWriter, r *http.Request) {
+	reader, err := s.Backend.Save(r.Context(), r.URL.Query().Get("names"))
+
+	if err != nil {
+		writeFakeDockerError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	defer reader.Close()
+
+	files, err := readTar(reader)
+
+	if err != nil {
+		writeFakeDockerError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if s.SaveFilter != nil {
+		files = s.SaveFilter(files)
+	}
+
+	data, err := writeFakeTar(files)
+
+	if err != nil {
+		writeFakeDockerError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.WriteHeader(http.StatusOK)
+
+	if !s.SaveBroken {
+		w.Write(data)
+		return
+	}
+
+	// Write a half of the tar and close the connection
+	w.Write(data[:len(data)/2])
+	w.(http.Flusher).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func writeFakeDockerError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+const (
+	graphFormatDOT     = "dot"
+	graphFormatMermaid = "mermaid"
+	graphFormatTree    = "tree"
+)
+
+type GraphOptions struct {
+	Format   string `long:"format" short:"f" description:"Output format" choice:"tree" choice:"dot" choice:"mermaid" default:"tree"`
+	Annotate bool   `long:"annotate" description:"Show base images and tags of builds"`
+}
+
+func init() {
+	var graphOptions GraphOptions
+
+	_, err := parser.AddCommand("graph", "Print the build graph", "Print the dependency graph of builds. If build names are given, only builds related to them are printed.", &graphOptions)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (g *GraphOptions) Execute(args []string) error {
+	config, err := InitConfig()
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	graph, err := NewBuildGraph(config, args, g.Annotate)
+
+	if err != nil {
+		return err
+	}
+
+	var output string
+
+	switch g.Format {
+	case graphFormatDOT:
+		output = graph.DOT()
+	case graphFormatMermaid:
+		output = graph.Mermaid()
+	default:
+		output = graph.Tree()
+	}
+
+	fmt.Print(output)
+	return nil
+}
+
+// BuildGraph renders the dependency graph of builds. Edges point from imported
+// builds to builds which import them.
+type BuildGraph struct {
+	config   *Config
+	nodes    []string
+	annotate bool
+}
+
+// NewBuildGraph returns the graph of the builds and all builds they depend on
+// or are depended on by. All builds are included if names are empty.
+func NewBuildGraph(config *Config, names []string, annotate bool) (*BuildGraph, error) {
+	g := &BuildGraph{
+		config:   config,
+		annotate: annotate,
+	}
+
+	if len(names) == 0 {
+		g.nodes = config.BuildNames()
+		return g, nil
+	}
+
+	nodes := NewStringSet()
+
+	for _, name := range names {
+		if _, ok := config.Build[name]; !ok {
+			return nil, merry.Errorf("build %q is not defined", name)
+		}
+
+		nodes.Insert(name)
+		nodes.Insert(config.FindAllDependencies(name).Slice()...)
+		nodes.Insert(config.FindAllDependants(name).Slice()...)
+	}
+
+	g.nodes = nodes.SortedSlice()
+	return g, nil
+}
+
+func (g *BuildGraph) contains(name string) bool {
+	return stringSliceContains(g.nodes, name)
+}
+
+// children returns sorted builds which import the build.
+func (g *BuildGraph) children(name string) (result []string) {
+	for _, dep := range g.config.FindDependants(name).SortedSlice() {
+		if g.contains(dep) {
+			result = append(result, dep)
+		}
+	}
+
+	return
+}
+
+func (g *BuildGraph) annotations(name string) []string {
+	if !g.annotate {
+		return nil
+	}
+
+	build := g.config.Build[name]
+	result := []string{"from: " + build.From}
+
+	if len(build.Tags) > 0 {
+		result = append(result, "tags: "+strings.Join(build.Tags, ", "))
+	}
+
+	return result
+}
+
+// DOT renders the graph in Graphviz DOT language.
+func (g *BuildGraph) DOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph layercake {\n")
+
+	for _, name := range g.nodes {
+		label := strings.Join(append([]string{name}, g.annotations(name)...), "\n")
+		fmt.Fprintf(&sb, "  %s [label=%s];\n", strconv.Quote(name), strconv.Quote(label))
+	}
+
+	for _, name := range g.nodes {
+		for _, child := range g.children(name) {
+			fmt.Fprintf(&sb, "  %s -> %s;\n", strconv.Quote(name), strconv.Quote(child))
+		}
+	}
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// Mermaid renders the graph in Mermaid flowchart syntax.
+func (g *BuildGraph) Mermaid() string {
+	var sb strings.Builder
+	ids := map[string]string{}
+	sb.WriteString("graph TD\n")
+
+	for i, name := range g.nodes {
+		ids[name] = "n" + strconv.Itoa(i)
+		label := strings.Join(append([]string{name}, g.annotations(name)...), "<br/>")
+		fmt.Fprintf(&sb, "  %s[\"%s\"]\n", ids[name], strings.Replace(label, `"`, "#quot;", -1))
+	}
+
+	for _, name := range g.nodes {
+		for _, child := range g.children(name) {
+			fmt.Fprintf(&sb, "  %s --> %s\n", ids[name], ids[child])
+		}
+	}
+
+	return sb.String()
+}
+
+// Tree renders the graph as plain text trees. Each root is a build which does
+// not import other builds in the graph. Builds importing several builds are
+// printed under each of them, but builds importing them are only printed the
+// first time, and later occurrences are marked with "(*)".
+func (g *BuildGraph) Tree() string {
+	var sb strings.Builder
+	printed := NewStringSet()
+
+	for _, name := range g.nodes {
+		isRoot := true
+
+		g.config.FindDependencies(name).Range(func(dep string) bool {
+			if g.contains(dep) {
+				isRoot = false
+				return false
+			}
+
+			return true
+		})
+
+		if isRoot {
+			sb.WriteString(g.treeLabel(name) + "\n")
+			printed.Insert(name)
+			g.writeTree(&sb, printed, name, "")
+		}
+	}
+
+	return sb.String()
+}
+
+func (g *BuildGraph) writeTree(sb *strings.Builder, printed StringSet, name, indent string) {
+	children := g.children(name)
+
+	for i, child := range children {
+		branch, next := "├── ", "│   "
+
+		if i == len(children)-1 {
+			branch, next = "└── ", "    "
+		}
+
+		if printed.Contains(child) {
+			sb.WriteString(indent + branch + child + " (*)\n")
+			continue
+		}
+
+		printed.Insert(child)
+		sb.WriteString(indent + branch + g.treeLabel(child) + "\n")
+		g.writeTree(sb, printed, child, indent+next)
+	}
+}
+
+func (g *BuildGraph) treeLabel(name string) string {
+	if a := g.annotations(name); len(a) > 0 {
+		return name + " (" + strings.Join(a, "; ") + ")"
+	}
+
+	return name
+}
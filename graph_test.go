@@ -0,0 +1,139 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestGraphConfig() *Config {
+	return &Config{
+		Build: map[string]BuildConfig{
+			"mozjpeg": {
+				From: "debian",
+			},
+			"imagequant": {
+				From: "debian",
+			},
+			"vips": {
+				From: "debian",
+				Tags: []string{"vips:latest", "vips:8"},
+				Scripts: []BuildScript{
+					{Import: "mozjpeg"},
+					{Import: "imagequant"},
+				},
+			},
+			"app": {
+				From: "alpine",
+				Scripts: []BuildScript{
+					{Import: "vips"},
+				},
+			},
+			"other": {
+				From: "busybox",
+			},
+		},
+	}
+}
+
+func TestNewBuildGraph(t *testing.T) {
+	config := newTestGraphConfig()
+
+	t.Run("All builds", func(t *testing.T) {
+		graph, err := NewBuildGraph(config, nil, false)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"app", "imagequant", "mozjpeg", "other", "vips"}, graph.nodes)
+	})
+
+	t.Run("Filtered", func(t *testing.T) {
+		graph, err := NewBuildGraph(config, []string{"mozjpeg"}, false)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"app", "mozjpeg", "vips"}, graph.nodes)
+	})
+
+	t.Run("Undefined build", func(t *testing.T) {
+		_, err := NewBuildGraph(config, []string{"foo"}, false)
+		assert.Error(t, err)
+	})
+}
+
+func TestBuildGraph_DOT(t *testing.T) {
+	graph, err := NewBuildGraph(newTestGraphConfig(), []string{"vips"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, strings.TrimLeft(`
+digraph layercake {
+  "app" [label="app\nfrom: alpine"];
+  "imagequant" [label="imagequant\nfrom: debian"];
+  "mozjpeg" [label="mozjpeg\nfrom: debian"];
+  "vips" [label="vips\nfrom: debian\ntags: vips:latest, vips:8"];
+  "imagequant" -> "vips";
+  "mozjpeg" -> "vips";
+  "vips" -> "app";
+}
+`, "\n"), graph.DOT())
+}
+
+func TestBuildGraph_Mermaid(t *testing.T) {
+	graph, err := NewBuildGraph(newTestGraphConfig(), []string{"vips"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, strings.TrimLeft(`
+graph TD
+  n0["app"]
+  n1["imagequant"]
+  n2["mozjpeg"]
+  n3["vips"]
+  n1 --> n3
+  n2 --> n3
+  n3 --> n0
+`, "\n"), graph.Mermaid())
+}
+
+func TestBuildGraph_Tree(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		graph, err := NewBuildGraph(newTestGraphConfig(), nil, false)
+		require.NoError(t, err)
+		assert.Equal(t, strings.TrimLeft(`
+imagequant
+└── vips
+    └── app
+mozjpeg
+└── vips (*)
+other
+`, "\n"), graph.Tree())
+	})
+
+	t.Run("Annotate", func(t *testing.T) {
+		graph, err := NewBuildGraph(newTestGraphConfig(), []string{"app"}, true)
+		require.NoError(t, err)
+		assert.Equal(t, strings.TrimLeft(`
+imagequant (from: debian)
+└── vips (from: debian; tags: vips:latest, vips:8)
+    └── app (from: alpine)
+mozjpeg (from: debian)
+└── vips (*)
+`, "\n"), graph.Tree())
+	})
+
+	t.Run("Diamond", func(t *testing.T) {
+		graph, err := NewBuildGraph(&Config{
+			Build: map[string]BuildConfig{
+				"base": {From: "alpine"},
+				"a":    {From: "alpine", Scripts: []BuildScript{{Import: "base"}}},
+				"b":    {From: "alpine", Scripts: []BuildScript{{Import: "base"}}},
+				"c":    {From: "alpine", Scripts: []BuildScript{{Import: "a"}, {Import: "b"}}},
+				"d":    {From: "alpine", Scripts: []BuildScript{{Import: "c"}}},
+			},
+		}, nil, false)
+		require.NoError(t, err)
+		assert.Equal(t, strings.TrimLeft(`
+base
+├── a
+│   └── c
+│       └── d
+└── b
+    └── c (*)
+`, "\n"), graph.Tree())
+	})
+}
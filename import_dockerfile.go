@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry"
+	dockerfile "github.com/moby/buildkit/frontend/dockerfile/parser"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ruleCopyFrom  = "copy-from"
+	ruleGlobalArg = "global-arg"
+	rulePlatform  = "platform"
+)
+
+type ImportDockerfileOptions struct {
+	Output string `long:"output" short:"o" description:"Path of the output config file. Print to stdout if not set." value-name:"PATH"`
+}
+
+func init() {
+	var importDockerfileOptions ImportDockerfileOptions
+
+	_, err := parser.AddCommand("import-dockerfile", "Convert a Dockerfile into a config", "Convert stages of a multi-stage Dockerfile into builds. Instructions which can't be translated are reported.", &importDockerfileOptions)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (i *ImportDockerfileOptions) Execute(args []string) error {
+	dockerfilePath := "Dockerfile"
+
+	if len(args) > 0 {
+		dockerfilePath = args[0]
+	}
+
+	if !filepath.IsAbs(dockerfilePath) {
+		dockerfilePath = filepath.Join(cwd, dockerfilePath)
+	}
+
+	file, err := os.Open(dockerfilePath)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	defer file.Close()
+
+	instructions, err := parseDockerfile(file)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	config, issues := convertDockerfile(filepath.Base(dockerfilePath), instructions)
+	data, err := yaml.Marshal(config)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	if i.Output == "" {
+		fmt.Print(string(data))
+	} else if err := ioutil.WriteFile(i.Output, data, 0644); err != nil {
+		return merry.Wrap(err)
+	}
+
+	errCount := 0
+
+	for _, issue := range issues {
+		fmt.Fprintln(os.Stderr, issue)
+
+		if issue.Severity == SeverityError {
+			errCount++
+		}
+	}
+
+	if errCount > 0 {
+		return merry.Errorf("%d instructions could not be translated", errCount)
+	}
+
+	return nil
+}
+
+// dockerfileInstruction is an instruction parsed from a Dockerfile.
+type dockerfileInstruction struct {
+	// Instruction in lower case
+	Command  string
+	Flags    []string
+	Args     []string
+	Original string
+	Line     int
+}
+
+// flag returns the value of the flag and whether it's set.
+func (d dockerfileInstruction) flag(name string) (string, bool) {
+	prefix := "--" + name + "="
+
+	for _, flag := range d.Flags {
+		if strings.HasPrefix(flag, prefix) {
+			return strings.TrimPrefix(flag, prefix), true
+		}
+	}
+
+	return "", false
+}
+
+func parseDockerfile(r io.Reader) ([]dockerfileInstruction, error) {
+	result, err := dockerfile.Parse(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var instructions []dockerfileInstruction
+
+	for _, node := range result.AST.Children {
+		instruction := dockerfileInstruction{
+			Command:  strings.ToLower(node.Value),
+			Flags:    node.Flags,
+			Original: node.Original,
+			Line:     node.StartLine,
+		}
+
+		for n := node.Next; n != nil; n = n.Next {
+			instruction.Args = append(instruction.Args, n.Value)
+		}
+
+		instructions = append(instructions, instruction)
+	}
+
+	return instructions, nil
+}
+
+type dockerfileConverter struct {
+	file   string
+	config *Config
+	issues []ValidationIssue
+
+	// Names of stages in order
+	stages []string
+}
+
+// convertDockerfile converts stages of a Dockerfile into builds. Unnamed
+// stages are named after their indexes. Instructions are copied as is, except
+// COPY --from=<stage>, which is converted into an import if possible. File is
+// the name of the Dockerfile used in issues.
+func convertDockerfile(file string, instructions []dockerfileInstruction) (*Config, []ValidationIssue) {
+	c := &dockerfileConverter{
+		file:   file,
+		config: &Config{Build: map[string]BuildConfig{}},
+	}
+
+	var stage string
+
+	for _, instruction := range instructions {
+		if instruction.Command == "from" {
+			stage = c.convertFrom(instruction)
+			continue
+		}
+
+		if stage == "" {
+			if instruction.Command == "arg" {
+				c.convertGlobalArg(instruction)
+			}
+
+			continue
+		}
+
+		build := c.config.Build[stage]
+		build.Scripts = append(build.Scripts, c.convertScript(instruction))
+		c.config.Build[stage] = build
+	}
+
+	return c.config, c.issues
+}
+
+func (c *dockerfileConverter) report(severity, rule string, instruction dockerfileInstruction, format string, args ...interface{}) {
+	pos := Position{File: c.file, Line: instruction.Line}
+	c.issues = append(c.issues, newValidationIssue(severity, rule, pos, format, args...))
+}
+
+// convertGlobalArg converts an ARG before the first FROM into a variable.
+func (c *dockerfileConverter) convertGlobalArg(instruction dockerfileInstruction) {
+	for _, arg := range instruction.Args {
+		parts := strings.SplitN(arg, "=", 2)
+
+		if len(parts) < 2 {
+			c.report(SeverityWarning, ruleGlobalArg, instruction,
+				"ARG %s has no default value, so it must be set as an environment variable", parts[0])
+			continue
+		}
+
+		if c.config.Variables == nil {
+			c.config.Variables = map[string]string{}
+		}
+
+		c.config.Variables[parts[0]] = parts[1]
+	}
+}
+
+// convertFrom adds a build for the stage and returns its name.
+func (c *dockerfileConverter) convertFrom(instruction dockerfileInstruction) string {
+	name := fmt.Sprintf("stage-%d", len(c.stages))
+	args := instruction.Args
+
+	if len(args) >= 3 && strings.EqualFold(args[1], "as") {
+		name = strings.ToLower(args[2])
+	}
+
+	if _, ok := instruction.flag("platform"); ok {
+		c.report(SeverityWarning, rulePlatform, instruction, "--platform of stage %q is ignored", name)
+	}
+
+	var build BuildConfig
+
+	// Stages based on another stage inherit its scripts
+	if len(args) > 0 {
+		if parent := strings.ToLower(args[0]); stringSliceContains(c.stages, parent) {
+			build.Extends.Name = parent
+		} else {
+			build.From = args[0]
+		}
+	}
+
+	c.stages = append(c.stages, name)
+	c.config.Build[name] = build
+
+	return name
+}
+
+func (c *dockerfileConverter) convertScript(instruction dockerfileInstruction) BuildScript {
+	if instruction.Command == "copy" {
+		if script, ok := c.convertCopyFrom(instruction); ok {
+			return script
+		}
+	}
+
+	return BuildScript{Raw: instruction.Original}
+}
+
+// convertCopyFrom converts COPY --from=<stage> into an import. Files are kept
+// in their full paths if the destination is the same as the source, otherwise
+// they are copied into the destination directory. It returns false if the
+// instruction is not COPY --from=<stage> or can't be converted.
+func (c *dockerfileConverter) convertCopyFrom(instruction dockerfileInstruction) (BuildScript, bool) {
+	from, ok := instruction.flag("from")
+
+	if !ok {
+		return BuildScript{}, false
+	}
+
+	stage := c.findStage(from)
+
+	// Images are copied by Docker
+	if stage == "" {
+		return BuildScript{}, false
+	}
+
+	fail := func(format string, args ...interface{}) (BuildScript, bool) {
+		c.report(SeverityError, ruleCopyFrom, instruction, "unable to import files from stage %q: %s", stage, fmt.Sprintf(format, args...))
+		return BuildScript{}, false
+	}
+
+	if len(instruction.Flags) > 1 {
+		return fail("flags other than --from are not supported")
+	}
+
+	if len(instruction.Args) < 2 {
+		return fail("source or destination is missing")
+	}
+
+	sources := instruction.Args[:len(instruction.Args)-1]
+	dest := instruction.Args[len(instruction.Args)-1]
+
+	for _, src := range sources {
+		if strings.ContainsAny(src, "*?[") {
+			return fail("wildcards are not supported")
+		}
+	}
+
+	script := BuildScript{
+		Import: stage,
+		ImportOptions: ImportOptions{
+			Paths: sources,
+		},
+	}
+
+	if len(sources) == 1 && path.Clean(sources[0]) == path.Clean(dest) {
+		return script, true
+	}
+
+	if !strings.HasSuffix(dest, "/") && len(sources) == 1 {
+		return fail("renaming files is not supported")
+	}
+
+	script.ImportOptions.To = dest
+
+	// Sources may be files or directories, which can't be told apart without
+	// the image
+	subject := sources[0]
+
+	if len(sources) > 1 {
+		subject = "any of " + strings.Join(sources, ", ")
+	}
+
+	c.report(SeverityWarning, ruleCopyFrom, instruction,
+		"if %s is a directory, it's imported into %s with its name, while COPY copies its contents", subject, dest)
+
+	return script, true
+}
+
+// findStage returns the name of the stage referenced by its name or index. It
+// returns an empty string if the stage is not found.
+func (c *dockerfileConverter) findStage(name string) string {
+	if i, err := strconv.Atoi(name); err == nil {
+		if i >= 0 && i < len(c.stages) {
+			return c.stages[i]
+		}
+
+		return ""
+	}
+
+	if name = strings.ToLower(name); stringSliceContains(c.stages, name) {
+		return name
+	}
+
+	return ""
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseDockerfile(t *testing.T) {
+	instructions, err := parseDockerfile(strings.NewReader(`FROM golang:1.12 AS builder
+RUN go build \
+  -o /app
+COPY --from=builder /app /app
+`))
+
+	require.NoError(t, err)
+	require.Len(t, instructions, 3)
+	assert.Equal(t, "from", instructions[0].Command)
+	assert.Equal(t, []string{"golang:1.12", "AS", "builder"}, instructions[0].Args)
+	assert.Equal(t, "FROM golang:1.12 AS builder", instructions[0].Original)
+	assert.Equal(t, 1, instructions[0].Line)
+	assert.Equal(t, "run", instructions[1].Command)
+	assert.Equal(t, 2, instructions[1].Line)
+	assert.Equal(t, []string{"--from=builder"}, instructions[2].Flags)
+	assert.Equal(t, []string{"/app", "/app"}, instructions[2].Args)
+}
+
+func TestConvertDockerfile(t *testing.T) {
+	from := func(line int, args ...string) dockerfileInstruction {
+		return dockerfileInstruction{
+			Command:  "from",
+			Args:     args,
+			Original: "FROM " + strings.Join(args, " "),
+			Line:     line,
+		}
+	}
+
+	copyFrom := func(line int, flags []string, args ...string) dockerfileInstruction {
+		return dockerfileInstruction{
+			Command:  "copy",
+			Flags:    flags,
+			Args:     args,
+			Original: "COPY " + strings.Join(append(append([]string{}, flags...), args...), " "),
+			Line:     line,
+		}
+	}
+
+	raw := func(line int, original string) dockerfileInstruction {
+		return dockerfileInstruction{
+			Command:  strings.ToLower(strings.Fields(original)[0]),
+			Args:     strings.Fields(original)[1:],
+			Original: original,
+			Line:     line,
+		}
+	}
+
+	t.Run("Stages", func(t *testing.T) {
+		config, issues := convertDockerfile("Dockerfile", []dockerfileInstruction{
+			from(1, "golang:1.12", "AS", "Builder"),
+			raw(2, "RUN go build -o /app"),
+			from(3, "alpine"),
+			copyFrom(4, []string{"--from=builder"}, "/app", "/app"),
+			copyFrom(5, []string{"--from=0"}, "/go/bin/a", "/go/bin/b", "/usr/bin/"),
+			copyFrom(6, []string{"--from=nginx"}, "/etc/nginx", "/etc/nginx"),
+		})
+
+		assert.Equal(t, map[string]BuildConfig{
+			"builder": {
+				From: "golang:1.12",
+				Scripts: []BuildScript{
+					{Raw: "RUN go build -o /app"},
+				},
+			},
+			"stage-1": {
+				From: "alpine",
+				Scripts: []BuildScript{
+					{Import: "builder", ImportOptions: ImportOptions{Paths: []string{"/app"}}},
+					{Import: "builder", ImportOptions: ImportOptions{Paths: []string{"/go/bin/a", "/go/bin/b"}, To: "/usr/bin/"}},
+					{Raw: "COPY --from=nginx /etc/nginx /etc/nginx"},
+				},
+			},
+		}, config.Build)
+		assert.Equal(t, []ValidationIssue{
+			{
+				Severity: SeverityWarning,
+				Rule:     ruleCopyFrom,
+				File:     "Dockerfile",
+				Line:     5,
+				Message:  "if any of /go/bin/a, /go/bin/b is a directory, it's imported into /usr/bin/ with its name, while COPY copies its contents",
+			},
+		}, issues)
+	})
+
+	t.Run("Based on another stage", func(t *testing.T) {
+		config, issues := convertDockerfile("Dockerfile", []dockerfileInstruction{
+			from(1, "alpine", "as", "base"),
+			raw(2, "RUN apk add --no-cache curl"),
+			from(3, "base"),
+			raw(4, "CMD [\"curl\"]"),
+		})
+
+		assert.Empty(t, issues)
+		assert.Equal(t, BuildConfig{
+			Extends: BuildExtends{Name: "base"},
+			Scripts: []BuildScript{
+				{Raw: "CMD [\"curl\"]"},
+			},
+		}, config.Build["stage-1"])
+	})
+
+	t.Run("Global args", func(t *testing.T) {
+		config, issues := convertDockerfile("Dockerfile", []dockerfileInstruction{
+			raw(1, "ARG GO_VERSION=1.12"),
+			raw(2, "ARG TARGET"),
+			from(3, "golang:${GO_VERSION}"),
+			raw(4, "RUN echo $$"),
+		})
+
+		assert.Equal(t, map[string]string{"GO_VERSION": "1.12"}, config.Variables)
+		assert.Equal(t, BuildConfig{
+			From: "golang:${GO_VERSION}",
+			Scripts: []BuildScript{
+				{Raw: "RUN echo $$"},
+			},
+		}, config.Build["stage-0"])
+		assert.Equal(t, []ValidationIssue{
+			{
+				Severity: SeverityWarning,
+				Rule:     ruleGlobalArg,
+				File:     "Dockerfile",
+				Line:     2,
+				Message:  "ARG TARGET has no default value, so it must be set as an environment variable",
+			},
+		}, issues)
+	})
+
+	t.Run("Untranslatable", func(t *testing.T) {
+		config, issues := convertDockerfile("Dockerfile", []dockerfileInstruction{
+			from(1, "golang", "AS", "builder"),
+			{Command: "from", Flags: []string{"--platform=linux/arm64"}, Args: []string{"alpine"}, Line: 2},
+			copyFrom(3, []string{"--from=builder", "--chown=app"}, "/app", "/app"),
+			copyFrom(4, []string{"--from=builder"}, "/app/*", "/app/"),
+			copyFrom(5, []string{"--from=builder"}, "/app", "/usr/bin/app"),
+		})
+
+		assert.Equal(t, []BuildScript{
+			{Raw: "COPY --from=builder --chown=app /app /app"},
+			{Raw: "COPY --from=builder /app/* /app/"},
+			{Raw: "COPY --from=builder /app /usr/bin/app"},
+		}, config.Build["stage-1"].Scripts)
+
+		var messages []string
+
+		for _, issue := range issues {
+			messages = append(messages, issue.String())
+		}
+
+		assert.Equal(t, []string{
+			`Dockerfile:2: warning: --platform of stage "stage-1" is ignored [platform]`,
+			`Dockerfile:3: error: unable to import files from stage "builder": flags other than --from are not supported [copy-from]`,
+			`Dockerfile:4: error: unable to import files from stage "builder": wildcards are not supported [copy-from]`,
+			`Dockerfile:5: error: unable to import files from stage "builder": renaming files is not supported [copy-from]`,
+		}, messages)
+	})
+
+	t.Run("Marshal", func(t *testing.T) {
+		config, _ := convertDockerfile("Dockerfile", []dockerfileInstruction{
+			raw(1, "ARG GO_VERSION=1.12"),
+			from(2, "golang:${GO_VERSION}", "AS", "builder"),
+			raw(3, "RUN go build -o /app"),
+			from(4, "builder", "AS", "test"),
+			raw(5, "RUN go test ./..."),
+			from(6, "alpine"),
+			copyFrom(7, []string{"--from=builder"}, "/app", "/app"),
+		})
+
+		data, err := yaml.Marshal(config)
+		require.NoError(t, err)
+
+		actual, err := LoadConfig(data)
+		require.NoError(t, err)
+		require.NoError(t, actual.Validate())
+
+		assertConfig(t, &Config{
+			Variables: map[string]string{"GO_VERSION": "1.12"},
+			Build: map[string]BuildConfig{
+				"builder": {
+					From: "golang:1.12",
+					Scripts: []BuildScript{
+						{Raw: "RUN go build -o /app"},
+					},
+				},
+				"test": {
+					From: "golang:1.12",
+					Scripts: []BuildScript{
+						{Raw: "RUN go build -o /app"},
+						{Raw: "RUN go test ./..."},
+					},
+				},
+				"stage-2": {
+					From: "alpine",
+					Scripts: []BuildScript{
+						{Import: "builder", ImportOptions: ImportOptions{Paths: []string{"/app"}}},
+					},
+				},
+			},
+		}, actual)
+	})
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// nolint: gochecknoglobals
+var variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// VariableLookup returns the value of a variable and whether it's defined.
+type VariableLookup func(name string) (string, bool)
+
+// Interpolate replaces "${VAR}" and "${VAR:-default}" in the string with
+// values of variables. The default value is used when the variable is
+// undefined or empty. "$$" is an escape of "$". It returns an error if a
+// variable is undefined.
+func Interpolate(s string, lookup VariableLookup) (string, error) {
+	return interpolate(s, lookup, false)
+}
+
+// interpolate replaces variables in the string. If keepUndefined is true,
+// expressions of undefined variables are left as is, so they can be expanded
+// by Docker later, and so is "$$", which is the process ID in shells.
+func interpolate(s string, lookup VariableLookup, keepUndefined bool) (string, error) {
+	var buf strings.Builder
+
+	for i := 0; i < len(s); {
+		if s[i] != '$' {
+			buf.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		if i+1 < len(s) && s[i+1] == '$' {
+			if keepUndefined {
+				buf.WriteString("$$")
+			} else {
+				buf.WriteByte('$')
+			}
+
+			i += 2
+			continue
+		}
+
+		if i+1 >= len(s) || s[i+1] != '{' {
+			buf.WriteByte('$')
+			i++
+			continue
+		}
+
+		end := strings.IndexByte(s[i+2:], '}')
+
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable in %q", s)
+		}
+
+		raw := s[i : i+end+3]
+		expr := raw[2 : len(raw)-1]
+		i += len(raw)
+
+		name, def := expr, ""
+		hasDefault := false
+
+		if idx := strings.Index(expr, ":-"); idx >= 0 {
+			name, def, hasDefault = expr[:idx], expr[idx+2:], true
+		}
+
+		if !variableNamePattern.MatchString(name) {
+			if keepUndefined {
+				buf.WriteString(raw)
+				continue
+			}
+
+			return "", fmt.Errorf("invalid variable %q", raw)
+		}
+
+		value, ok := lookup(name)
+
+		switch {
+		case ok && (value != "" || !hasDefault):
+			buf.WriteString(value)
+
+		case keepUndefined:
+			buf.WriteString(raw)
+
+		case hasDefault:
+			buf.WriteString(def)
+
+		default:
+			return "", fmt.Errorf("undefined variable %q", name)
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// variableLookup returns a lookup function which reads variables from the
+// environment first and then variables in the config.
+func (c *Config) variableLookup() VariableLookup {
+	return func(name string) (string, bool) {
+		if value, ok := os.LookupEnv(name); ok {
+			return value, true
+		}
+
+		value, ok := c.Variables[name]
+		return value, ok
+	}
+}
+
+// scriptVariableLookup returns a lookup function for scripts. Only variables
+// defined in the config are available in scripts, so environment variables
+// like PATH are not expanded by accident.
+func (c *Config) scriptVariableLookup() VariableLookup {
+	lookup := c.variableLookup()
+
+	return func(name string) (string, bool) {
+		if _, ok := c.Variables[name]; !ok {
+			return "", false
+		}
+
+		return lookup(name)
+	}
+}
+
+// Interpolate replaces variables in builds. Variables in the config can
+// reference environment variables only. Undefined variables in scripts are
+// left as is because they may be build arguments or environment variables
+// defined in Dockerfile.
+func (c *Config) Interpolate() error {
+	var errs ConfigErrors
+
+	for name, value := range c.Variables {
+		value, err := Interpolate(value, os.LookupEnv)
+
+		if err != nil {
+			errs = append(errs, newConfigError(c.variablePosition(name), "variable %q: %s", name, err))
+			continue
+		}
+
+		c.Variables[name] = value
+	}
+
+	if err := errs.Err(); err != nil {
+		return err
+	}
+
+	lookup := c.variableLookup()
+	scriptLookup := c.scriptVariableLookup()
+
+	for _, name := range c.BuildNames() {
+		build := c.Build[name]
+
+		// Matrix values take precedence over other variables
+		buildErrs := build.interpolate(
+			name,
+			withMatrixValues(build.MatrixValues, lookup),
+			withMatrixValues(build.MatrixValues, scriptLookup),
+		)
+
+		errs = append(errs, buildErrs...)
+		c.Build[name] = build
+	}
+
+	return errs.Err()
+}
+
+func withMatrixValues(values map[string]string, lookup VariableLookup) VariableLookup {
+	if len(values) == 0 {
+		return lookup
+	}
+
+	return func(name string) (string, bool) {
+		if value, ok := values[name]; ok {
+			return value, true
+		}
+
+		return lookup(name)
+	}
+}
+
+// interpolate replaces variables in the build. Slices and maps are copied
+// because they may be shared with other builds.
+func (b *BuildConfig) interpolate(name string, lookup, scriptLookup VariableLookup) (errs ConfigErrors) {
+	var err error
+
+	fail := func(pos Position, err error) {
+		errs = append(errs, newConfigError(pos, "build %q: %s", name, err))
+	}
+
+	if b.From, err = Interpolate(b.From, lookup); err != nil {
+		fail(b.position("from"), err)
+	}
+
+	if b.Tags, err = interpolateSlice(b.Tags, lookup); err != nil {
+		fail(b.position("tags"), err)
+	}
+
+	if b.CacheFrom, err = interpolateSlice(b.CacheFrom, lookup); err != nil {
+		fail(b.position("cache_from"), err)
+	}
+
+	if b.Platforms, err = interpolateSlice(b.Platforms, lookup); err != nil {
+		fail(b.position("platforms"), err)
+	}
+
+	if b.Secrets, err = interpolateSlice(b.Secrets, lookup); err != nil {
+		fail(b.position("secrets"), err)
+	}
+
+	if b.Args, err = interpolateMap(b.Args, lookup); err != nil {
+		fail(b.position("args"), err)
+	}
+
+	if b.Labels, err = interpolateMap(b.Labels, lookup); err != nil {
+		fail(b.position("labels"), err)
+	}
+
+	if b.Scripts == nil {
+		return
+	}
+
+	scripts := make([]BuildScript, len(b.Scripts))
+
+	// Undefined variables in scripts aren't errors. They are left for the
+	// Dockerfile to expand.
+	for i, script := range b.Scripts {
+		if script.Raw, err = interpolate(script.Raw, scriptLookup, true); err != nil {
+			fail(script.pos, err)
+		}
+
+		if script.Value, err = interpolate(script.Value, scriptLookup, true); err != nil {
+			fail(script.pos, err)
+		}
+
+		if script.Mounts != nil {
+			mounts := make([]string, len(script.Mounts))
+
+			for j, mount := range script.Mounts {
+				if mounts[j], err = interpolate(mount, scriptLookup, true); err != nil {
+					fail(script.pos, err)
+				}
+			}
+
+			script.Mounts = mounts
+		}
+
+		scripts[i] = script
+	}
+
+	b.Scripts = scripts
+	return
+}
+
+func interpolateSlice(values []string, lookup VariableLookup) ([]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+
+	result := make([]string, len(values))
+
+	for i, value := range values {
+		value, err := Interpolate(value, lookup)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result[i] = value
+	}
+
+	return result, nil
+}
+
+func interpolateMap(values map[string]string, lookup VariableLookup) (map[string]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+
+	result := map[string]string{}
+
+	for k, v := range values {
+		value, err := Interpolate(v, lookup)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result[k] = value
+	}
+
+	return result, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testVariableLookup(vars map[string]string) VariableLookup {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	lookup := testVariableLookup(map[string]string{
+		"FOO":   "foo",
+		"EMPTY": "",
+	})
+
+	tests := []struct {
+		Name     string
+		Input    string
+		Expected string
+		Error    string
+	}{
+		{
+			Name:     "No variables",
+			Input:    "alpine:3.9",
+			Expected: "alpine:3.9",
+		},
+		{
+			Name:     "Variable",
+			Input:    "a-${FOO}-b",
+			Expected: "a-foo-b",
+		},
+		{
+			Name:     "Multiple variables",
+			Input:    "${FOO}${FOO}",
+			Expected: "foofoo",
+		},
+		{
+			Name:     "Default value of undefined variable",
+			Input:    "${BAR:-bar}",
+			Expected: "bar",
+		},
+		{
+			Name:     "Default value of empty variable",
+			Input:    "${EMPTY:-bar}",
+			Expected: "bar",
+		},
+		{
+			Name:     "Empty variable",
+			Input:    "a${EMPTY}b",
+			Expected: "ab",
+		},
+		{
+			Name:     "Default value is not used",
+			Input:    "${FOO:-bar}",
+			Expected: "foo",
+		},
+		{
+			Name:     "Escape",
+			Input:    "$${FOO} $$",
+			Expected: "${FOO} $",
+		},
+		{
+			Name:     "Variable without braces",
+			Input:    "$FOO $",
+			Expected: "$FOO $",
+		},
+		{
+			Name:  "Undefined variable",
+			Input: "${BAR}",
+			Error: `undefined variable "BAR"`,
+		},
+		{
+			Name:  "Invalid variable",
+			Input: "${FOO:+bar}",
+			Error: `invalid variable "${FOO:+bar}"`,
+		},
+		{
+			Name:  "Unterminated variable",
+			Input: "${FOO",
+			Error: `unterminated variable in "${FOO"`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			actual, err := Interpolate(test.Input, lookup)
+
+			if test.Error != "" {
+				assert.EqualError(t, err, test.Error)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, test.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestConfig_Interpolate(t *testing.T) {
+	require.NoError(t, os.Setenv("LAYERCAKE_TEST_TAG", "env"))
+	defer os.Unsetenv("LAYERCAKE_TEST_TAG")
+
+	t.Run("Success", func(t *testing.T) {
+		config := &Config{
+			Variables: map[string]string{
+				"ALPINE_VERSION":     "3.9",
+				"LAYERCAKE_TEST_TAG": "var",
+				"IMAGE":              "foo:${LAYERCAKE_TEST_TAG}",
+			},
+			Build: map[string]BuildConfig{
+				"foo": {
+					From:      "alpine:${ALPINE_VERSION}",
+					Tags:      []string{"foo:${LAYERCAKE_TEST_TAG}"},
+					CacheFrom: []string{"${IMAGE}"},
+					Args:      map[string]string{"version": "${ALPINE_VERSION}"},
+					Labels:    map[string]string{"tag": "${LAYERCAKE_TEST_TAG:-latest}"},
+					Scripts: []BuildScript{
+						{Raw: "RUN echo ${ALPINE_VERSION} ${HOME_DIR}"},
+						{Raw: "RUN echo $$ > /tmp/pid"},
+						{Instruction: "ENV", Value: "PATH=${PATH}:/app/bin"},
+						{Instruction: "LABEL", Value: "tag=${LAYERCAKE_TEST_TAG}"},
+					},
+				},
+			},
+		}
+
+		require.NoError(t, config.Interpolate())
+		assert.Equal(t, "foo:env", config.Variables["IMAGE"])
+		assert.Equal(t, BuildConfig{
+			From:      "alpine:3.9",
+			Tags:      []string{"foo:env"},
+			CacheFrom: []string{"foo:env"},
+			Args:      map[string]string{"version": "3.9"},
+			Labels:    map[string]string{"tag": "env"},
+			Scripts: []BuildScript{
+				{Raw: "RUN echo 3.9 ${HOME_DIR}"},
+				{Raw: "RUN echo $$ > /tmp/pid"},
+				{Instruction: "ENV", Value: "PATH=${PATH}:/app/bin"},
+				{Instruction: "LABEL", Value: "tag=env"},
+			},
+		}, config.Build["foo"])
+	})
+
+	t.Run("Undefined variable", func(t *testing.T) {
+		config := &Config{
+			Build: map[string]BuildConfig{
+				"foo": {From: "alpine:${LAYERCAKE_TEST_UNDEFINED}"},
+			},
+		}
+
+		assert.EqualError(t, config.Interpolate(), `build "foo": undefined variable "LAYERCAKE_TEST_UNDEFINED"`)
+	})
+
+	t.Run("Undefined variable in variables", func(t *testing.T) {
+		config := &Config{
+			Variables: map[string]string{
+				"FOO": "${LAYERCAKE_TEST_UNDEFINED}",
+			},
+		}
+
+		assert.EqualError(t, config.Interpolate(), `variable "FOO": undefined variable "LAYERCAKE_TEST_UNDEFINED"`)
+	})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+const (
+	// LayerSetLast is the last layer of an image.
+	LayerSetLast = "last"
+
+	// LayerSetSinceBase is all layers added on top of the base image.
+	LayerSetSinceBase = "since-base"
+
+	// LayerSetAll is the whole file system of an image.
+	LayerSetAll = "all"
+)
+
+// baseLayersLabel is added after FROM in builds whose since-base layers are
+// imported. Its entry in the image history separates layers of the base image
+// from layers added by the build.
+const baseLayersLabel = "layercake.base-image"
+
+// nolint: gochecknoglobals
+var layerSets = []string{LayerSetLast, LayerSetSinceBase, LayerSetAll}
+
+// normalizeTarName converts a tar entry name to a clean relative path.
+func normalizeTarName(name string) string {
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
+
+type layerMergeState struct {
+	emitted StringSet
+	deleted StringSet
+	opaque  StringSet
+}
+
+func (s *layerMergeState) isHidden(name string) bool {
+	if s.emitted.Contains(name) || s.deleted.Contains(name) {
+		return true
+	}
+
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if s.deleted.Contains(dir) || s.opaque.Contains(dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MergeLayers merges layer tars into a single tar without whiteout files and
+// writes it to w. Layers are ordered from the lowest to the highest. Files in
+// higher layers override files in lower layers, and whiteouts delete files from
+// lower layers.
+func MergeLayers(paths []string, w io.Writer) error {
+	state := &layerMergeState{
+		emitted: NewStringSet(),
+		deleted: NewStringSet(),
+		opaque:  NewStringSet(),
+	}
+	keep := make([]StringSet, len(paths))
+
+	// Find visible entries from the highest layer to the lowest layer
+	for i := len(paths) - 1; i >= 0; i-- {
+		var whiteouts []Whiteout
+		keep[i] = NewStringSet()
+
+		err := readLayer(paths[i], func(header *tar.Header, _ io.Reader) error {
+			name := normalizeTarName(header.Name)
+
+			if wh, ok := ParseWhiteout(name); ok {
+				if wh.Path != "" {
+					whiteouts = append(whiteouts, wh)
+				}
+
+				return nil
+			}
+
+			if !state.isHidden(name) {
+				keep[i].Insert(name)
+				state.emitted.Insert(name)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		// Whiteouts only apply to lower layers
+		for _, wh := range whiteouts {
+			if wh.Opaque {
+				state.opaque.Insert(wh.Path)
+			} else {
+				state.deleted.Insert(wh.Path)
+			}
+		}
+	}
+
+	// Write entries from the lowest layer to the highest layer, so hard links
+	// are always written after their targets.
+	tw := tar.NewWriter(w)
+
+	for i, p := range paths {
+		err := readLayer(p, func(header *tar.Header, r io.Reader) error {
+			if !keep[i].Contains(normalizeTarName(header.Name)) {
+				return nil
+			}
+
+			_, err := TarAddFile(tw, header, r)
+			return err
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return merry.Wrap(tw.Close())
+}
+
+// FilterLayer copies entries of the layer tar to w. fn is called with the
+// normalized name of each entry and returns the new name of the entry, or false
+// if the entry should be skipped. Hard links whose targets are skipped are
+// skipped as well.
+func FilterLayer(r io.Reader, w io.Writer, fn func(name string) (string, bool)) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return merry.Wrap(err)
+		}
+
+		name, ok := fn(normalizeTarName(header.Name))
+
+		if !ok {
+			continue
+		}
+
+		header.Name = name
+
+		if header.Typeflag == tar.TypeLink {
+			if header.Linkname, ok = fn(normalizeTarName(header.Linkname)); !ok {
+				continue
+			}
+		}
+
+		if _, err := TarAddFile(tw, header, tr); err != nil {
+			return err
+		}
+	}
+
+	return merry.Wrap(tw.Close())
+}
+
+func readLayer(path string, fn func(header *tar.Header, r io.Reader) error) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	defer file.Close()
+
+	tr := tar.NewReader(file)
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return merry.Wrap(err)
+		}
+
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func createLayerFile(dst string, fn func(w io.Writer) error) error {
+	file, err := os.Create(dst)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	defer file.Close()
+
+	if err := fn(file); err != nil {
+		return err
+	}
+
+	return merry.Wrap(file.Close())
+}
+
+func stripWhiteoutsFile(src, dst string) (whiteouts []Whiteout, err error) {
+	file, err := os.Open(src)
+
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	defer file.Close()
+
+	err = createLayerFile(dst, func(w io.Writer) (err error) {
+		whiteouts, err = StripWhiteouts(file, w)
+		return
+	})
+
+	return
+}
+
+func mergeLayersFile(srcs []string, dst string) error {
+	return createLayerFile(dst, func(w io.Writer) error {
+		return MergeLayers(srcs, w)
+	})
+}
+
+func filterLayerFile(src, dst string, fn func(name string) (string, bool)) error {
+	file, err := os.Open(src)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	defer file.Close()
+
+	return createLayerFile(dst, func(w io.Writer) error {
+		return FilterLayer(file, w, fn)
+	})
+}
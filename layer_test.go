@@ -0,0 +1,120 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeTarName(t *testing.T) {
+	tests := map[string]string{
+		"foo":      "foo",
+		"./foo":    "foo",
+		"/foo/":    "foo",
+		"foo/bar/": "foo/bar",
+		"./":       "",
+	}
+
+	for input, expected := range tests {
+		assert.Equal(t, expected, normalizeTarName(input), input)
+	}
+}
+
+func TestMergeLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layercake")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	layers := [][]tarFile{
+		{
+			{Name: "a/"},
+			{Name: "a/1", Data: []byte("a1")},
+			{Name: "a/2", Data: []byte("a2")},
+			{Name: "b/"},
+			{Name: "b/1", Data: []byte("b1")},
+			{Name: "c", Data: []byte("c")},
+		},
+		{
+			{Name: ".wh.c"},
+			{Name: "b/"},
+			{Name: "b/.wh..wh..opq"},
+			{Name: "b/2", Data: []byte("b2")},
+		},
+		{
+			{Name: "./a/1", Data: []byte("new a1")},
+			{Name: "a/.wh.2"},
+		},
+	}
+
+	var paths []string
+
+	for i, files := range layers {
+		path := filepath.Join(dir, strings.Repeat("l", i+1)+".tar")
+		require.NoError(t, ioutil.WriteFile(path, writeTestTar(t, files).Bytes(), os.ModePerm))
+		paths = append(paths, path)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, MergeLayers(paths, &buf))
+
+	files, err := readTar(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, []tarFile{
+		{Name: "a/", Data: []byte{}},
+		{Name: "b/", Data: []byte{}},
+		{Name: "b/2", Data: []byte("b2")},
+		{Name: "./a/1", Data: []byte("new a1")},
+	}, files)
+}
+
+func TestFilterLayer(t *testing.T) {
+	var input bytes.Buffer
+	tw := tar.NewWriter(&input)
+	headers := []*tar.Header{
+		{Name: "usr/bin/foo", Typeflag: tar.TypeReg},
+		{Name: "usr/bin/bar", Typeflag: tar.TypeLink, Linkname: "usr/bin/foo"},
+		{Name: "usr/bin/baz", Typeflag: tar.TypeLink, Linkname: "usr/lib/baz"},
+		{Name: "usr/lib/baz", Typeflag: tar.TypeReg},
+	}
+
+	for _, header := range headers {
+		require.NoError(t, tw.WriteHeader(header))
+	}
+
+	require.NoError(t, tw.Close())
+
+	var output bytes.Buffer
+	err := FilterLayer(&input, &output, func(name string) (string, bool) {
+		if strings.HasPrefix(name, "usr/bin/") {
+			return "bin/" + filepath.Base(name), true
+		}
+
+		return "", false
+	})
+	require.NoError(t, err)
+
+	tr := tar.NewReader(&output)
+	var actual []*tar.Header
+
+	for {
+		header, err := tr.Next()
+
+		if err != nil {
+			break
+		}
+
+		actual = append(actual, header)
+	}
+
+	require.Len(t, actual, 2)
+	assert.Equal(t, "bin/foo", actual[0].Name)
+	assert.Equal(t, "bin/bar", actual[1].Name)
+	assert.Equal(t, "bin/foo", actual[1].Linkname)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	mediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// ManifestDescriptor describes a manifest in a manifest list.
+type ManifestDescriptor struct {
+	Digest   string
+	Size     int64
+	Platform Platform
+}
+
+type manifestList struct {
+	SchemaVersion int                 `json:"schemaVersion"`
+	MediaType     string              `json:"mediaType"`
+	Manifests     []manifestListEntry `json:"manifests"`
+}
+
+type manifestListEntry struct {
+	MediaType string   `json:"mediaType"`
+	Size      int64    `json:"size"`
+	Digest    string   `json:"digest"`
+	Platform  Platform `json:"platform"`
+}
+
+type registryTokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+// PushManifestList puts the manifest list to the registry of the tag.
+// Manifests must be pushed to the same repository first.
+func PushManifestList(ctx context.Context, config RegistryConfig, tag string, manifests []ManifestDescriptor) error {
+	named, err := reference.ParseNormalizedNamed(tag)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	tagged, ok := reference.TagNameOnly(named).(reference.Tagged)
+
+	if !ok {
+		return merry.Errorf("%q is not a tag reference", tag)
+	}
+
+	list := manifestList{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeManifestList,
+	}
+
+	for _, m := range manifests {
+		list.Manifests = append(list.Manifests, manifestListEntry{
+			MediaType: mediaTypeManifest,
+			Size:      m.Size,
+			Digest:    m.Digest,
+			Platform:  m.Platform,
+		})
+	}
+
+	data, err := json.Marshal(list)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	host := reference.Domain(named)
+
+	if host == dockerHubDomain {
+		host = dockerHubRegistryHost
+	}
+
+	endpoints, err := config.endpoints(host)
+
+	if err != nil {
+		return err
+	}
+
+	var c *http.Client
+	var u string
+	var res *http.Response
+
+	// Fall back to the next endpoint if the registry is unreachable
+	for _, endpoint := range endpoints {
+		c = endpoint.Client
+		u = fmt.Sprintf("%s/v2/%s/manifests/%s", endpoint.URL, reference.Path(named), tagged.Tag())
+
+		if res, err = putManifestList(ctx, c, u, data, ""); err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		res.Body.Close()
+		authorization, err := authorizeRegistry(ctx, c, res.Header.Get("WWW-Authenticate"), tag)
+
+		if err != nil {
+			return err
+		}
+
+		if res, err = putManifestList(ctx, c, u, data, authorization); err != nil {
+			return err
+		}
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return merry.Errorf("failed to push the manifest list of %s: %s %s", tag, res.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+func putManifestList(ctx context.Context, c *http.Client, u string, data []byte, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(data))
+
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	req.Header.Set("Content-Type", mediaTypeManifestList)
+
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	res, err := c.Do(req.WithContext(ctx))
+	return res, merry.Wrap(err)
+}
+
+// authorizeRegistry returns the Authorization header for the challenge of the
+// registry. Bearer tokens are requested with credentials of the image.
+func authorizeRegistry(ctx context.Context, c *http.Client, challenge, image string) (string, error) {
+	scheme, params := parseAuthChallenge(challenge)
+	auth, err := GetRegistryAuth(image)
+
+	if err != nil {
+		return "", err
+	}
+
+	switch scheme {
+	case "basic":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password)), nil
+
+	case "bearer":
+		token, err := fetchRegistryToken(ctx, c, params, auth)
+
+		if err != nil {
+			return "", err
+		}
+
+		return "Bearer " + token, nil
+	}
+
+	return "", merry.Errorf("unsupported authentication scheme of the registry: %q", challenge)
+}
+
+// fetchRegistryToken requests a bearer token from the realm of the challenge.
+// Identity tokens are exchanged with OAuth2, and passwords are sent with
+// basic authentication.
+func fetchRegistryToken(ctx context.Context, c *http.Client, params map[string]string, auth *types.AuthConfig) (string, error) {
+	realm := params["realm"]
+
+	if realm == "" {
+		return "", merry.New("the authentication challenge of the registry has no realm")
+	}
+
+	var req *http.Request
+	var err error
+
+	if auth.IdentityToken != "" {
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", auth.IdentityToken)
+		form.Set("service", params["service"])
+		form.Set("scope", params["scope"])
+		form.Set("client_id", "layercake")
+
+		if req, err = http.NewRequest(http.MethodPost, realm, strings.NewReader(form.Encode())); err != nil {
+			return "", merry.Wrap(err)
+		}
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := url.Values{}
+
+		for _, key := range []string{"service", "scope"} {
+			if v := params[key]; v != "" {
+				query.Set(key, v)
+			}
+		}
+
+		if req, err = http.NewRequest(http.MethodGet, realm+"?"+query.Encode(), nil); err != nil {
+			return "", merry.Wrap(err)
+		}
+
+		if auth.Username != "" {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+	}
+
+	res, err := c.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", merry.Errorf("failed to authenticate with the registry: %s", res.Status)
+	}
+
+	var token registryTokenResponse
+
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+
+	return token.Token, nil
+}
+
+// parseAuthChallenge parses the WWW-Authenticate header. It returns the scheme
+// in lower case and parameters of the challenge.
+func parseAuthChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	header = strings.TrimSpace(header)
+	idx := strings.IndexByte(header, ' ')
+
+	if idx < 0 {
+		return strings.ToLower(header), params
+	}
+
+	scheme := strings.ToLower(header[:idx])
+	rest := header[idx+1:]
+
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.IndexByte(rest, '=')
+
+		if eq < 0 {
+			break
+		}
+
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+
+		var value string
+
+		if strings.HasPrefix(rest, `"`) {
+			// Quoted values may contain commas and escaped characters
+			var buf strings.Builder
+			i := 1
+
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+
+				buf.WriteByte(rest[i])
+			}
+
+			value = buf.String()
+
+			if i < len(rest) {
+				i++
+			}
+
+			rest = rest[i:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+
+			if end < 0 {
+				end = len(rest)
+			}
+
+			value = strings.TrimSpace(rest[:end])
+			rest = rest[end:]
+		}
+
+		params[key] = value
+	}
+
+	return scheme, params
+}
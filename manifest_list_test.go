@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushManifestList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layercake")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	os.Setenv("DOCKER_CONFIG", dir)
+	defer os.Unsetenv("DOCKER_CONFIG")
+
+	manifests := []ManifestDescriptor{
+		{
+			Digest:   "sha256:0000000000000000000000000000000000000000000000000000000000000001",
+			Size:     100,
+			Platform: Platform{OS: "linux", Architecture: "amd64"},
+		},
+		{
+			Digest:   "sha256:0000000000000000000000000000000000000000000000000000000000000002",
+			Size:     200,
+			Platform: Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+		},
+	}
+
+	var body []byte
+	var contentType string
+	mux := http.NewServeMux()
+	server := httptest.NewUnstartedServer(mux)
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	insecureServer := httptest.NewServer(mux)
+	defer insecureServer.Close()
+
+	host := server.Listener.Addr().String()
+	insecureHost := insecureServer.Listener.Addr().String()
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+
+		if username != "foo" || password != "bar" || r.URL.Query().Get("scope") != "repository:app:push,pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"token": "secret"})
+	})
+
+	mux.HandleFunc("/v2/app/manifests/1.0", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			scheme := "https"
+
+			if r.TLS == nil {
+				scheme = "http"
+			}
+
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+scheme+`://`+r.Host+`/token",service="registry",scope="repository:app:push,pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		contentType = r.Header.Get("Content-Type")
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	conf := []byte(`{"auths": {
+		"` + host + `": {"username": "foo", "password": "bar"},
+		"` + insecureHost + `": {"username": "foo", "password": "bar"}
+	}}`)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), conf, os.ModePerm))
+
+	// The certificate of the server is trusted with the certs directory
+	certsDir := filepath.Join(dir, "certs.d")
+	require.NoError(t, os.MkdirAll(filepath.Join(certsDir, host), os.ModePerm))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(certsDir, host, "ca.crt"), pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), os.ModePerm))
+
+	assertManifestList := func(t *testing.T) {
+		assert.Equal(t, mediaTypeManifestList, contentType)
+
+		var list manifestList
+		require.NoError(t, json.Unmarshal(body, &list))
+		assert.Equal(t, 2, list.SchemaVersion)
+		assert.Equal(t, mediaTypeManifestList, list.MediaType)
+		require.Len(t, list.Manifests, 2)
+
+		for i, m := range list.Manifests {
+			assert.Equal(t, mediaTypeManifest, m.MediaType)
+			assert.Equal(t, manifests[i].Digest, m.Digest)
+			assert.Equal(t, manifests[i].Size, m.Size)
+			assert.Equal(t, manifests[i].Platform, m.Platform)
+		}
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		body = nil
+		require.NoError(t, PushManifestList(context.Background(), RegistryConfig{CertsDir: certsDir}, host+"/app:1.0", manifests))
+		assertManifestList(t)
+	})
+
+	t.Run("Untrusted certificate", func(t *testing.T) {
+		err := PushManifestList(context.Background(), RegistryConfig{}, host+"/app:1.0", manifests)
+		assert.Error(t, err)
+	})
+
+	t.Run("Insecure registry", func(t *testing.T) {
+		body = nil
+		config := RegistryConfig{
+			SecureRegistries: map[string]bool{insecureHost: false},
+		}
+
+		require.NoError(t, PushManifestList(context.Background(), config, insecureHost+"/app:1.0", manifests))
+		assertManifestList(t)
+	})
+
+	t.Run("HTTP is not used for secure registries", func(t *testing.T) {
+		err := PushManifestList(context.Background(), RegistryConfig{}, insecureHost+"/app:1.0", manifests)
+		assert.Error(t, err)
+	})
+
+	t.Run("Push failed", func(t *testing.T) {
+		err := PushManifestList(context.Background(), RegistryConfig{CertsDir: certsDir}, host+"/app:2.0", manifests)
+		require.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), "failed to push the manifest list of "+host+"/app:2.0: 404"), err.Error())
+	})
+
+	t.Run("Digest", func(t *testing.T) {
+		tag := host + "/app@sha256:" + strings.Repeat("0", 64)
+		err := PushManifestList(context.Background(), RegistryConfig{CertsDir: certsDir}, tag, manifests)
+		assert.EqualError(t, err, fmt.Sprintf("%q is not a tag reference", tag))
+	})
+}
+
+func TestParseAuthChallenge(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Header string
+		Scheme string
+		Params map[string]string
+	}{
+		{
+			Name:   "Bearer",
+			Header: `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:foo/bar:pull,push"`,
+			Scheme: "bearer",
+			Params: map[string]string{
+				"realm":   "https://auth.docker.io/token",
+				"service": "registry.docker.io",
+				"scope":   "repository:foo/bar:pull,push",
+			},
+		},
+		{
+			Name:   "Basic",
+			Header: `Basic realm="Registry Realm"`,
+			Scheme: "basic",
+			Params: map[string]string{"realm": "Registry Realm"},
+		},
+		{
+			Name:   "Unquoted values",
+			Header: `Bearer realm=https://example.com/token, service=example`,
+			Scheme: "bearer",
+			Params: map[string]string{
+				"realm":   "https://example.com/token",
+				"service": "example",
+			},
+		},
+		{
+			Name:   "Escaped quotes",
+			Header: `Basic realm="foo \"bar\""`,
+			Scheme: "basic",
+			Params: map[string]string{"realm": `foo "bar"`},
+		},
+		{
+			Name:   "No parameters",
+			Header: "Basic",
+			Scheme: "basic",
+			Params: map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			scheme, params := parseAuthChallenge(test.Header)
+			assert.Equal(t, test.Scheme, scheme)
+			assert.Equal(t, test.Params, params)
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// nolint: gochecknoglobals
+var invalidBuildNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+type matrixVariant struct {
+	Name   string
+	Values map[string]string
+}
+
+// Variants returns all combinations of the matrix. Keys are sorted, and names
+// of variants are generated by appending values to the name of the build.
+func (b BuildConfig) Variants(name string) ([]matrixVariant, error) {
+	keys := make([]string, 0, len(b.Matrix))
+
+	for key, values := range b.Matrix {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("matrix %q must have at least one value", key)
+		}
+
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	result := []matrixVariant{{Name: name, Values: map[string]string{}}}
+
+	for _, key := range keys {
+		var next []matrixVariant
+
+		for _, variant := range result {
+			for _, value := range b.Matrix[key] {
+				values := map[string]string{key: value}
+
+				for k, v := range variant.Values {
+					values[k] = v
+				}
+
+				next = append(next, matrixVariant{
+					Name:   variant.Name + "-" + invalidBuildNameChars.ReplaceAllString(value, "_"),
+					Values: values,
+				})
+			}
+		}
+
+		result = next
+	}
+
+	return result, nil
+}
+
+// matches returns true if values of common keys are the same.
+func (v matrixVariant) matches(values map[string]string) bool {
+	for key, expected := range v.Values {
+		if value, ok := values[key]; ok && value != expected {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ExpandMatrix replaces builds with a matrix with their variants. Imports of
+// a build with a matrix are resolved to the variant whose matrix values match
+// values of the importing build.
+func (c *Config) ExpandMatrix() error {
+	var errs ConfigErrors
+	builds := map[string]BuildConfig{}
+	variants := map[string][]matrixVariant{}
+
+	for _, name := range c.BuildNames() {
+		build := c.Build[name]
+
+		if len(build.Matrix) == 0 {
+			builds[name] = build
+			continue
+		}
+
+		result, err := build.Variants(name)
+
+		if err != nil {
+			errs = append(errs, newConfigError(build.position("matrix"), "build %q: %s", name, err))
+			continue
+		}
+
+		variants[name] = result
+	}
+
+	if len(variants) == 0 {
+		return errs.Err()
+	}
+
+	for name, result := range variants {
+		for _, variant := range result {
+			if _, ok := builds[variant.Name]; ok {
+				errs = append(errs, newConfigError(c.Build[name].position(""), "variant %q of build %q conflicts with another build", variant.Name, name))
+				continue
+			}
+
+			build := c.Build[name]
+			build.Matrix = nil
+			build.MatrixValues = variant.Values
+			builds[variant.Name] = build
+		}
+	}
+
+	for name, build := range builds {
+		scripts := make([]BuildScript, len(build.Scripts))
+
+		for i, script := range build.Scripts {
+			if result, ok := variants[script.Import]; ok {
+				var matched []string
+
+				for _, variant := range result {
+					if variant.matches(build.MatrixValues) {
+						matched = append(matched, variant.Name)
+					}
+				}
+
+				if len(matched) != 1 {
+					errs = append(errs, newConfigError(script.pos, "build %q imports %q, which matches %d variants (%s)", name, script.Import, len(matched), strings.Join(matched, ", ")))
+				} else {
+					script.Import = matched[0]
+				}
+			}
+
+			scripts[i] = script
+		}
+
+		if build.Scripts != nil {
+			build.Scripts = scripts
+		}
+
+		builds[name] = build
+	}
+
+	if err := errs.Err(); err != nil {
+		return err
+	}
+
+	c.Build = builds
+	return nil
+}
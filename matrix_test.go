@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildConfig_Variants(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		build := BuildConfig{
+			Matrix: map[string][]string{
+				"version": {"1.0", "2.0"},
+				"debian":  {"stretch", "buster/slim"},
+			},
+		}
+
+		actual, err := build.Variants("foo")
+		require.NoError(t, err)
+		assert.Equal(t, []matrixVariant{
+			{Name: "foo-stretch-1.0", Values: map[string]string{"debian": "stretch", "version": "1.0"}},
+			{Name: "foo-stretch-2.0", Values: map[string]string{"debian": "stretch", "version": "2.0"}},
+			{Name: "foo-buster_slim-1.0", Values: map[string]string{"debian": "buster/slim", "version": "1.0"}},
+			{Name: "foo-buster_slim-2.0", Values: map[string]string{"debian": "buster/slim", "version": "2.0"}},
+		}, actual)
+	})
+
+	t.Run("Empty values", func(t *testing.T) {
+		build := BuildConfig{
+			Matrix: map[string][]string{"version": {}},
+		}
+
+		_, err := build.Variants("foo")
+		assert.EqualError(t, err, `matrix "version" must have at least one value`)
+	})
+}
+
+func TestConfig_ExpandMatrix(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		config := &Config{
+			Build: map[string]BuildConfig{
+				"lib": {
+					From:   "debian:${debian}",
+					Matrix: map[string][]string{"debian": {"stretch", "buster"}},
+				},
+				"app": {
+					From:   "debian:${debian}",
+					Matrix: map[string][]string{"debian": {"stretch", "buster"}},
+					Scripts: []BuildScript{
+						{Import: "lib"},
+						{Import: "lib-stretch"},
+					},
+				},
+				"other": {
+					From:    "alpine",
+					Scripts: []BuildScript{{Import: "lib-buster"}},
+				},
+			},
+		}
+
+		require.NoError(t, config.ExpandMatrix())
+		require.NoError(t, config.Interpolate())
+		assert.Equal(t, map[string]BuildConfig{
+			"lib-stretch": {
+				From:         "debian:stretch",
+				MatrixValues: map[string]string{"debian": "stretch"},
+			},
+			"lib-buster": {
+				From:         "debian:buster",
+				MatrixValues: map[string]string{"debian": "buster"},
+			},
+			"app-stretch": {
+				From:         "debian:stretch",
+				MatrixValues: map[string]string{"debian": "stretch"},
+				Scripts: []BuildScript{
+					{Import: "lib-stretch"},
+					{Import: "lib-stretch"},
+				},
+			},
+			"app-buster": {
+				From:         "debian:buster",
+				MatrixValues: map[string]string{"debian": "buster"},
+				Scripts: []BuildScript{
+					{Import: "lib-buster"},
+					{Import: "lib-stretch"},
+				},
+			},
+			"other": {
+				From:    "alpine",
+				Scripts: []BuildScript{{Import: "lib-buster"}},
+			},
+		}, config.Build)
+	})
+
+	t.Run("Ambiguous import", func(t *testing.T) {
+		config := &Config{
+			Build: map[string]BuildConfig{
+				"lib": {
+					From:   "alpine",
+					Matrix: map[string][]string{"version": {"1", "2"}},
+				},
+				"app": {
+					From:    "alpine",
+					Scripts: []BuildScript{{Import: "lib"}},
+				},
+			},
+		}
+
+		assert.EqualError(t, config.ExpandMatrix(), `build "app" imports "lib", which matches 2 variants (lib-1, lib-2)`)
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		config := &Config{
+			Build: map[string]BuildConfig{
+				"lib": {
+					From:   "alpine",
+					Matrix: map[string][]string{"version": {"1"}},
+				},
+				"lib-1": {From: "alpine"},
+			},
+		}
+
+		assert.EqualError(t, config.ExpandMatrix(), `variant "lib-1" of build "lib" conflicts with another build`)
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BuildPlan describes what the build command does for each build, in the
+// order builds are started.
+type BuildPlan struct {
+	Steps []BuildPlanStep
+}
+
+// BuildPlanStep describes a build in the plan.
+type BuildPlanStep struct {
+	Name      string
+	From      string
+	Platforms []string
+	Args      map[string]*string
+	Tags      []string
+	Labels    map[string]string
+
+	// Import scripts of the build
+	Imports []BuildScript
+
+	// Layer sets exported for other builds
+	Exports []string
+
+	Dockerfile string
+}
+
+// NewBuildPlan returns the plan of the builds, which must be sorted by their
+// dependencies. Build arguments from the command line are overridden by
+// arguments of builds.
+func NewBuildPlan(config *Config, names []string, buildArgs []FlagMap) *BuildPlan {
+	plan := &BuildPlan{}
+
+	for _, name := range names {
+		build := config.Build[name]
+		step := BuildPlanStep{
+			Name:       name,
+			From:       build.From,
+			Platforms:  build.Platforms,
+			Args:       resolveBuildArgs(buildArgs, build),
+			Tags:       build.Tags,
+			Labels:     build.Labels,
+			Exports:    config.RequiredLayerSets(name).SortedSlice(),
+			Dockerfile: config.BuildDockerfile(name),
+		}
+
+		for _, script := range build.Scripts {
+			if script.Import != "" {
+				step.Imports = append(step.Imports, script)
+			}
+		}
+
+		plan.Steps = append(plan.Steps, step)
+	}
+
+	return plan
+}
+
+// resolveBuildArgs merges build arguments from the command line and the build.
+// A nil value means the argument is set without a value.
+func resolveBuildArgs(buildArgs []FlagMap, build BuildConfig) map[string]*string {
+	result := map[string]*string{}
+
+	for _, arg := range buildArgs {
+		result[arg.Key] = arg.Value
+	}
+
+	for k, v := range build.Args {
+		v := v
+		result[k] = &v
+	}
+
+	return result
+}
+
+func (p *BuildPlan) String() string {
+	if len(p.Steps) == 0 {
+		return "No builds to run\n"
+	}
+
+	var buf strings.Builder
+
+	for i, step := range p.Steps {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+
+		fmt.Fprintf(&buf, "[%d/%d] %s\n", i+1, len(p.Steps), step.Name)
+		step.write(&buf)
+	}
+
+	return buf.String()
+}
+
+func (s BuildPlanStep) write(buf *strings.Builder) {
+	writeList := func(title string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+
+		fmt.Fprintf(buf, "  %s:\n", title)
+
+		for _, v := range values {
+			fmt.Fprintf(buf, "    %s\n", v)
+		}
+	}
+
+	fmt.Fprintf(buf, "  From: %s\n", s.From)
+	writeList("Platforms", s.Platforms)
+
+	var args []string
+
+	for _, k := range sortedBuildArgKeys(s.Args) {
+		if v := s.Args[k]; v != nil {
+			args = append(args, k+"="+*v)
+		} else {
+			args = append(args, k)
+		}
+	}
+
+	writeList("Args", args)
+	writeList("Tags", s.Tags)
+
+	var labels []string
+
+	for _, k := range sortedMapKeys(s.Labels) {
+		labels = append(labels, k+"="+s.Labels[k])
+	}
+
+	writeList("Labels", labels)
+
+	var imports []string
+
+	for _, script := range s.Imports {
+		imports = append(imports, describeImport(script))
+	}
+
+	writeList("Imports", imports)
+	writeList("Exports", s.Exports)
+	writeList("Dockerfile", strings.Split(s.Dockerfile, "\n"))
+}
+
+// describeImport returns a summary of the import, e.g.
+// "builder (layers: all, paths: /usr/bin/foo, to: /bin)".
+func describeImport(script BuildScript) string {
+	options := script.ImportOptions
+	details := []string{"layers: " + options.LayerSet()}
+
+	if len(options.Paths) > 0 {
+		details = append(details, "paths: "+strings.Join(options.Paths, ", "))
+	}
+
+	if options.To != "" {
+		details = append(details, "to: "+options.To)
+	}
+
+	return fmt.Sprintf("%s (%s)", script.Import, strings.Join(details, ", "))
+}
+
+func sortedBuildArgKeys(m map[string]*string) []string {
+	keys := NewStringSet()
+
+	for k := range m {
+		keys.Insert(k)
+	}
+
+	return keys.SortedSlice()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBuildPlan(t *testing.T) {
+	config, err := LoadConfig([]byte(`
+build:
+  app:
+    from: alpine
+    platforms:
+      - linux/amd64
+      - linux/arm64
+    tags:
+      - app:latest
+    args:
+      VERSION: "1.0"
+    labels:
+      maintainer: foo
+    scripts:
+      - import: builder
+      - import:
+          from: builder
+          paths:
+            - /usr/local/bin/hello
+          to: /usr/bin
+      - cmd: hello
+  builder:
+    from: golang:alpine
+    scripts:
+      - run: go build -o /usr/local/bin/hello .
+`))
+
+	require.NoError(t, err)
+
+	names, err := config.SortBuildsWithDependencies([]string{"app"})
+	require.NoError(t, err)
+
+	value := "bar"
+	plan := NewBuildPlan(config, names, []FlagMap{
+		{Key: "FOO", Value: &value},
+		{Key: "VERSION", Value: &value},
+		{Key: "PROXY"},
+	})
+
+	t.Run("Steps", func(t *testing.T) {
+		require.Len(t, plan.Steps, 2)
+		assert.Equal(t, "builder", plan.Steps[0].Name)
+		assert.Equal(t, []string{LayerSetAll, LayerSetLast}, plan.Steps[0].Exports)
+		assert.Equal(t, "app", plan.Steps[1].Name)
+		assert.Empty(t, plan.Steps[1].Exports)
+		assert.Len(t, plan.Steps[1].Imports, 2)
+	})
+
+	t.Run("String", func(t *testing.T) {
+		assert.Equal(t, `[1/2] builder
+  From: golang:alpine
+  Args:
+    FOO=bar
+    PROXY
+    VERSION=bar
+  Exports:
+    all
+    last
+  Dockerfile:
+    FROM golang:alpine
+    RUN go build -o /usr/local/bin/hello .
+
+[2/2] app
+  From: alpine
+  Platforms:
+    linux/amd64
+    linux/arm64
+  Args:
+    FOO=bar
+    PROXY
+    VERSION=1.0
+  Tags:
+    app:latest
+  Labels:
+    maintainer=foo
+  Imports:
+    builder (layers: last)
+    builder (layers: all, paths: /usr/local/bin/hello, to: /usr/bin)
+  Dockerfile:
+    FROM alpine
+    ADD .layercake/builder.tar /
+    ADD .layercake/`+plan.Steps[1].Imports[1].ImportFile()+` /usr/bin
+    CMD hello
+`, plan.String())
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		assert.Equal(t, "No builds to run\n", NewBuildPlan(config, nil, nil).String())
+	})
+
+	t.Run("Since-base layers", func(t *testing.T) {
+		config, err := LoadConfig([]byte(`
+build:
+  app:
+    from: alpine
+    scripts:
+      - import:
+          from: builder
+          layers: since-base
+  builder:
+    from: golang:alpine
+    scripts:
+      - run: go build -o /usr/local/bin/hello .
+`))
+
+		require.NoError(t, err)
+
+		plan := NewBuildPlan(config, []string{"builder", "app"}, nil)
+		require.Len(t, plan.Steps, 2)
+		assert.Equal(t, `FROM golang:alpine
+LABEL layercake.base-image=golang:alpine
+RUN go build -o /usr/local/bin/hello .`, plan.Steps[0].Dockerfile)
+		assert.Equal(t, config.Build["app"].Dockerfile(), plan.Steps[1].Dockerfile)
+	})
+}
+
+func TestResolveBuildArgs(t *testing.T) {
+	a, b := "a", "b"
+	actual := resolveBuildArgs([]FlagMap{{Key: "A", Value: &a}, {Key: "B", Value: &b}, {Key: "C"}}, BuildConfig{
+		Args: map[string]string{"B": "c"},
+	})
+
+	require.Len(t, actual, 3)
+	assert.Equal(t, "a", *actual["A"])
+	assert.Equal(t, "c", *actual["B"])
+	assert.Nil(t, actual["C"])
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/docker/distribution/reference"
+)
+
+// Platform is a target platform of images, e.g. linux/arm64/v8.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ParsePlatform parses a platform in the format of os/arch[/variant].
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(strings.ToLower(s), "/")
+
+	if len(parts) < 2 || len(parts) > 3 {
+		return Platform{}, merry.Errorf("invalid platform %q, it must be in the format of os/arch[/variant]", s)
+	}
+
+	for _, part := range parts {
+		if part == "" {
+			return Platform{}, merry.Errorf("invalid platform %q, it must be in the format of os/arch[/variant]", s)
+		}
+	}
+
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+
+	return p, nil
+}
+
+// parsePlatformFlags returns platforms in values of the --platform option.
+// Each value may contain multiple platforms separated by commas.
+func parsePlatformFlags(values []string) ([]string, error) {
+	var result []string
+
+	for _, value := range values {
+		for _, s := range strings.Split(value, ",") {
+			p, err := ParsePlatform(strings.TrimSpace(s))
+
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, p.String())
+		}
+	}
+
+	return result, nil
+}
+
+// initPlatformConfig loads the config and overrides platforms of builds with
+// values of the --platform option.
+func initPlatformConfig(flags []string) (*Config, error) {
+	config, err := InitConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	platforms, err := parsePlatformFlags(flags)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(platforms) > 0 {
+		config.SetPlatforms(platforms)
+	}
+
+	return config, nil
+}
+
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+
+	return s
+}
+
+// Matches returns true if both platforms have the same OS and architecture.
+// Variants are only compared if both are set, because Docker may not report
+// variants of images.
+func (p Platform) Matches(other Platform) bool {
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+
+	return p.Variant == "" || other.Variant == "" || p.Variant == other.Variant
+}
+
+// samePlatform returns true if both strings are the same platform. Builds
+// without platforms are represented by empty strings.
+func samePlatform(a, b string) bool {
+	if a == "" || b == "" {
+		return a == b
+	}
+
+	pa, err := ParsePlatform(a)
+
+	if err != nil {
+		return a == b
+	}
+
+	pb, err := ParsePlatform(b)
+
+	if err != nil {
+		return a == b
+	}
+
+	return pa == pb
+}
+
+// platformTag returns the tag of the image built for the platform, which has
+// the platform as a suffix, e.g. foo:1.0-linux-arm64.
+func platformTag(tag, platform string) (string, error) {
+	suffix := strings.Replace(strings.ToLower(platform), "/", "-", -1)
+	named, err := reference.ParseNormalizedNamed(tag)
+
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	tagged, ok := reference.TagNameOnly(named).(reference.Tagged)
+
+	if !ok {
+		return "", merry.Errorf("%q is not a tag reference", tag)
+	}
+
+	result, err := reference.WithTag(reference.TrimNamed(named), tagged.Tag()+"-"+suffix)
+
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+
+	return reference.FamiliarString(result), nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Input    string
+		Expected Platform
+		Error    bool
+	}{
+		{
+			Name:     "OS and architecture",
+			Input:    "linux/amd64",
+			Expected: Platform{OS: "linux", Architecture: "amd64"},
+		},
+		{
+			Name:     "Variant",
+			Input:    "linux/arm64/v8",
+			Expected: Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		},
+		{
+			Name:     "Upper case",
+			Input:    "Linux/AMD64",
+			Expected: Platform{OS: "linux", Architecture: "amd64"},
+		},
+		{
+			Name:  "OS only",
+			Input: "linux",
+			Error: true,
+		},
+		{
+			Name:  "Empty architecture",
+			Input: "linux/",
+			Error: true,
+		},
+		{
+			Name:  "Too many parts",
+			Input: "linux/arm/v7/foo",
+			Error: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			actual, err := ParsePlatform(test.Input)
+
+			if test.Error {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, test.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestPlatform_String(t *testing.T) {
+	assert.Equal(t, "linux/amd64", Platform{OS: "linux", Architecture: "amd64"}.String())
+	assert.Equal(t, "linux/arm/v7", Platform{OS: "linux", Architecture: "arm", Variant: "v7"}.String())
+}
+
+func TestPlatform_Matches(t *testing.T) {
+	arm := Platform{OS: "linux", Architecture: "arm"}
+	armv6 := Platform{OS: "linux", Architecture: "arm", Variant: "v6"}
+	armv7 := Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+
+	assert.True(t, armv7.Matches(armv7))
+	assert.True(t, arm.Matches(armv7))
+	assert.True(t, armv7.Matches(arm))
+	assert.False(t, armv6.Matches(armv7))
+	assert.False(t, arm.Matches(Platform{OS: "linux", Architecture: "amd64"}))
+	assert.False(t, arm.Matches(Platform{OS: "windows", Architecture: "arm"}))
+}
+
+func TestParsePlatformFlags(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		actual, err := parsePlatformFlags([]string{"linux/amd64, linux/arm64", "Linux/ARM/v7"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"linux/amd64", "linux/arm64", "linux/arm/v7"}, actual)
+	})
+
+	t.Run("Invalid platform", func(t *testing.T) {
+		_, err := parsePlatformFlags([]string{"linux/amd64,"})
+		assert.Error(t, err)
+	})
+}
+
+func TestPlatformTag(t *testing.T) {
+	tests := []struct {
+		Tag      string
+		Platform string
+		Expected string
+	}{
+		{Tag: "foo", Platform: "linux/amd64", Expected: "foo:latest-linux-amd64"},
+		{Tag: "foo:1.0", Platform: "linux/arm/v7", Expected: "foo:1.0-linux-arm-v7"},
+		{Tag: "localhost:5000/foo/bar:1.0", Platform: "linux/arm64", Expected: "localhost:5000/foo/bar:1.0-linux-arm64"},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Tag, func(t *testing.T) {
+			actual, err := platformTag(test.Tag, test.Platform)
+			require.NoError(t, err)
+			assert.Equal(t, test.Expected, actual)
+		})
+	}
+
+	t.Run("Digest", func(t *testing.T) {
+		_, err := platformTag("foo@sha256:"+strings.Repeat("0", 64), "linux/amd64")
+		assert.EqualError(t, err, `"foo@sha256:`+strings.Repeat("0", 64)+`" is not a tag reference`)
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/ansel1/merry"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+type PushOptions struct {
+	Platforms []string `long:"platform" description:"Push images built for the platforms instead of platforms of builds" value-name:"PLATFORM"`
+
+	ctx     context.Context
+	backend Backend
+	config  *Config
+}
+
+func init() {
+	var pushOptions PushOptions
+
+	_, err := parser.AddCommand("push", "Push images", "Push all tags of builds. All builds are pushed if no build names are given.", &pushOptions)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (p *PushOptions) Execute(args []string) error {
+	p.ctx = globalCtx
+
+	if err := RunSeries(p.initConfig, p.initBackend); err != nil {
+		return err
+	}
+
+	names := args
+
+	if len(names) == 0 {
+		names = p.config.BuildNames()
+	}
+
+	for _, name := range names {
+		if _, ok := p.config.Build[name]; !ok {
+			return merry.Errorf("build %q is not defined", name)
+		}
+	}
+
+	return PushBuilds(p.ctx, p.backend, p.config, names, os.Stdout)
+}
+
+func (p *PushOptions) initConfig() (err error) {
+	p.config, err = initPlatformConfig(p.Platforms)
+	return
+}
+
+func (p *PushOptions) initBackend() error {
+	c, err := NewDockerClient(p.ctx)
+
+	if err != nil {
+		return err
+	}
+
+	// Images are not built by the push command
+	p.backend = NewDockerBackend(c, nil)
+	return nil
+}
+
+// PushBuilds pushes all tags of the builds. Tags of builds for multiple
+// platforms are pushed as manifest lists of platform images.
+func PushBuilds(ctx context.Context, backend Backend, config *Config, names []string, out io.Writer) error {
+	for _, name := range names {
+		log := logger.WithField("prefix", name)
+		build := config.Build[name]
+
+		if len(build.Tags) == 0 {
+			log.Debug("No tags to push")
+			continue
+		}
+
+		for _, tag := range build.Tags {
+			if len(build.Platforms) < 2 {
+				log.WithField("tag", tag).Info("Pushing the image")
+
+				if _, err := backend.Push(ctx, tag, out); err != nil {
+					log.WithField("tag", tag).Error("Failed to push the image")
+					return merry.Wrap(err)
+				}
+
+				continue
+			}
+
+			if err := pushManifestList(ctx, backend, build, tag, out); err != nil {
+				log.WithField("tag", tag).Error("Failed to push the manifest list")
+				return merry.Wrap(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// pushManifestList pushes images of all platforms and a manifest list of them.
+func pushManifestList(ctx context.Context, backend Backend, build BuildConfig, tag string, out io.Writer) error {
+	var manifests []ManifestDescriptor
+
+	for _, platform := range build.Platforms {
+		image, err := platformTag(tag, platform)
+
+		if err != nil {
+			return err
+		}
+
+		logger.WithField("tag", image).Info("Pushing the image")
+
+		result, err := backend.Push(ctx, image, out)
+
+		if err != nil {
+			return err
+		}
+
+		p, err := ParsePlatform(platform)
+
+		if err != nil {
+			return err
+		}
+
+		manifests = append(manifests, ManifestDescriptor{
+			Digest:   result.Digest,
+			Size:     result.Size,
+			Platform: p,
+		})
+	}
+
+	logger.WithField("tag", tag).Info("Pushing the manifest list")
+	return backend.PushManifestList(ctx, tag, manifests)
+}
+
+// PushImage pushes the image with credentials in the Docker config file. It
+// returns the manifest of the pushed image.
+func PushImage(ctx context.Context, c client.ImageAPIClient, image string, out io.Writer) (*PushResult, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	image = reference.FamiliarString(reference.TagNameOnly(named))
+	auth, err := GetRegistryAuth(image)
+
+	if err != nil {
+		return nil, err
+	}
+
+	encodedAuth, err := EncodeRegistryAuth(auth)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := c.ImagePush(ctx, image, types.ImagePushOptions{
+		RegistryAuth: encodedAuth,
+	})
+
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	defer reader.Close()
+
+	return DisplayPushStream(reader, out)
+}
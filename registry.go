@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/docker/docker/api/types/registry"
+)
+
+const defaultRegistryCertsDir = "/etc/docker/certs.d"
+
+// RegistryConfig configures connections to registries. It follows the registry
+// config of Docker, so manifest lists are pushed to registries the same way as
+// images.
+type RegistryConfig struct {
+	// Whether registries are secure. It takes precedence over InsecureCIDRs.
+	SecureRegistries map[string]bool
+
+	// Registries whose IPs are in these networks are insecure
+	InsecureCIDRs []*net.IPNet
+
+	// Directory of registry certificates in the layout of /etc/docker/certs.d
+	CertsDir string
+}
+
+// registryEndpoint is a base URL of a registry and the client to access it.
+type registryEndpoint struct {
+	URL    string
+	Client *http.Client
+}
+
+// newDaemonRegistryConfig returns the registry config of the Docker daemon.
+func newDaemonRegistryConfig(config *registry.ServiceConfig) RegistryConfig {
+	result := RegistryConfig{
+		SecureRegistries: map[string]bool{},
+		CertsDir:         defaultRegistryCertsDir,
+	}
+
+	if config == nil {
+		return result
+	}
+
+	for name, index := range config.IndexConfigs {
+		result.SecureRegistries[name] = index.Secure
+	}
+
+	for _, cidr := range config.InsecureRegistryCIDRs {
+		ipNet := net.IPNet(*cidr)
+		result.InsecureCIDRs = append(result.InsecureCIDRs, &ipNet)
+	}
+
+	return result
+}
+
+// endpoints returns endpoints of the registry in order of preference. Insecure
+// registries are accessed with HTTPS without verifying certificates first, and
+// then with HTTP.
+func (c RegistryConfig) endpoints(host string) ([]registryEndpoint, error) {
+	tlsConfig, err := c.tlsConfig(host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	insecure := c.isInsecure(host)
+	tlsConfig.InsecureSkipVerify = insecure
+
+	result := []registryEndpoint{
+		{URL: "https://" + host, Client: newRegistryHTTPClient(tlsConfig)},
+	}
+
+	if insecure {
+		result = append(result, registryEndpoint{URL: "http://" + host, Client: newRegistryHTTPClient(nil)})
+	}
+
+	return result, nil
+}
+
+// isInsecure returns true if the registry may be accessed with HTTP or
+// untrusted certificates.
+func (c RegistryConfig) isInsecure(host string) bool {
+	if secure, ok := c.SecureRegistries[host]; ok {
+		return !secure
+	}
+
+	hostname := host
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	ips := []net.IP{net.ParseIP(strings.Trim(hostname, "[]"))}
+
+	// Hostnames are resolved like Docker
+	if ips[0] == nil {
+		var err error
+
+		if ips, err = net.LookupIP(hostname); err != nil {
+			return false
+		}
+	}
+
+	for _, ip := range ips {
+		for _, cidr := range c.InsecureCIDRs {
+			if cidr.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// tlsConfig returns the TLS config of the registry with certificates in the
+// certs directory. As in Docker, "*.crt" files are CA certificates, and
+// "*.cert" and "*.key" files are client key pairs.
+func (c RegistryConfig) tlsConfig(host string) (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if c.CertsDir == "" {
+		return config, nil
+	}
+
+	dir := filepath.Join(c.CertsDir, host)
+	files, err := ioutil.ReadDir(dir)
+
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+
+		switch filepath.Ext(path) {
+		case ".crt":
+			if config.RootCAs == nil {
+				if config.RootCAs, err = x509.SystemCertPool(); err != nil {
+					config.RootCAs = x509.NewCertPool()
+				}
+			}
+
+			data, err := ioutil.ReadFile(path)
+
+			if err != nil {
+				return nil, merry.Wrap(err)
+			}
+
+			if !config.RootCAs.AppendCertsFromPEM(data) {
+				return nil, merry.Errorf("invalid CA certificate %q", path)
+			}
+
+		case ".cert":
+			cert, err := tls.LoadX509KeyPair(path, strings.TrimSuffix(path, ".cert")+".key")
+
+			if err != nil {
+				return nil, merry.Wrap(err)
+			}
+
+			config.Certificates = append(config.Certificates, cert)
+		}
+	}
+
+	return config, nil
+}
+
+func newRegistryHTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   10 * time.Second,
+			IdleConnTimeout:       90 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDaemonRegistryConfig(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	actual := newDaemonRegistryConfig(&registry.ServiceConfig{
+		InsecureRegistryCIDRs: []*registry.NetIPNet{(*registry.NetIPNet)(loopback)},
+		IndexConfigs: map[string]*registry.IndexInfo{
+			"docker.io":     {Name: "docker.io", Secure: true},
+			"example.local": {Name: "example.local", Secure: false},
+		},
+	})
+
+	assert.Equal(t, RegistryConfig{
+		SecureRegistries: map[string]bool{"docker.io": true, "example.local": false},
+		InsecureCIDRs:    []*net.IPNet{loopback},
+		CertsDir:         defaultRegistryCertsDir,
+	}, actual)
+}
+
+func TestRegistryConfig_isInsecure(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	config := RegistryConfig{
+		SecureRegistries: map[string]bool{
+			"registry.local:5000": false,
+			"127.0.0.1:443":       true,
+		},
+		InsecureCIDRs: []*net.IPNet{loopback, private},
+	}
+
+	tests := []struct {
+		Host     string
+		Expected bool
+	}{
+		{Host: "registry.local:5000", Expected: true},
+		{Host: "127.0.0.1:443", Expected: false},
+		{Host: "127.0.0.1:5000", Expected: true},
+		{Host: "10.0.0.1", Expected: true},
+		{Host: "[::1]:5000", Expected: false},
+		{Host: "192.168.0.1:5000", Expected: false},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Host, func(t *testing.T) {
+			assert.Equal(t, test.Expected, config.isInsecure(test.Host))
+		})
+	}
+}
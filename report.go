@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// BuildReport records results of builds. It's safe for concurrent use.
+type BuildReport struct {
+	Builds []*BuildReportEntry `json:"builds"`
+
+	lock sync.Mutex
+}
+
+type BuildReportEntry struct {
+	Name       string              `json:"name"`
+	Platform   string              `json:"platform,omitempty"`
+	Dockerfile string              `json:"dockerfile"`
+	ImageID    string              `json:"image_id,omitempty"`
+	Tags       []string            `json:"tags"`
+	StartedAt  time.Time           `json:"started_at"`
+	Duration   float64             `json:"duration"`
+	Layers     []*LayerReportEntry `json:"layers"`
+	Success    bool                `json:"success"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// LayerReportEntry records a layer set exported for other builds.
+type LayerReportEntry struct {
+	Set    string `json:"set"`
+	Size   int64  `json:"size"`
+	Cached bool   `json:"cached"`
+}
+
+func NewBuildReport() *BuildReport {
+	return &BuildReport{
+		Builds: []*BuildReportEntry{},
+	}
+}
+
+// Start adds a build for the platform to the report. The returned entry should
+// only be modified by the goroutine running the build.
+func (r *BuildReport) Start(name, platform, dockerfile string, tags []string) *BuildReportEntry {
+	entry := &BuildReportEntry{
+		Name:       name,
+		Platform:   platform,
+		Dockerfile: dockerfile,
+		Tags:       tags,
+		StartedAt:  time.Now(),
+		Layers:     []*LayerReportEntry{},
+	}
+
+	if entry.Tags == nil {
+		entry.Tags = []string{}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.Builds = append(r.Builds, entry)
+	return entry
+}
+
+// WriteFile writes the report to the file in JSON.
+func (r *BuildReport) WriteFile(path string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	data, err := json.MarshalIndent(r, "", "  ")
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	return merry.Wrap(ioutil.WriteFile(path, data, 0644))
+}
+
+// AddLayer records the layer set at the path.
+func (e *BuildReportEntry) AddLayer(set, path string, cached bool) error {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	e.Layers = append(e.Layers, &LayerReportEntry{
+		Set:    set,
+		Size:   info.Size(),
+		Cached: cached,
+	})
+
+	return nil
+}
+
+// Finish records the duration and the result of the build.
+func (e *BuildReportEntry) Finish(err error) {
+	e.Duration = time.Since(e.StartedAt).Seconds()
+	e.Success = err == nil
+
+	if err != nil {
+		e.Error = err.Error()
+	}
+}
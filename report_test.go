@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layercake")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	layerPath := filepath.Join(dir, "last.tar")
+	require.NoError(t, ioutil.WriteFile(layerPath, []byte("foo"), os.ModePerm))
+
+	report := NewBuildReport()
+
+	foo := report.Start("foo", "", "FROM alpine", []string{"foo:latest"})
+	foo.ImageID = "sha256:abc"
+	require.NoError(t, foo.AddLayer(LayerSetLast, layerPath, true))
+	assert.Error(t, foo.AddLayer(LayerSetAll, filepath.Join(dir, "all.tar"), false))
+	foo.Finish(nil)
+
+	bar := report.Start("bar", "linux/arm64", "FROM busybox", nil)
+	bar.Finish(errors.New("failed"))
+
+	reportPath := filepath.Join(dir, "report.json")
+	require.NoError(t, report.WriteFile(reportPath))
+
+	data, err := ioutil.ReadFile(reportPath)
+	require.NoError(t, err)
+
+	var actual map[string][]map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &actual))
+	require.Len(t, actual["builds"], 2)
+
+	fooReport := actual["builds"][0]
+	assert.Equal(t, "foo", fooReport["name"])
+	assert.NotContains(t, fooReport, "platform")
+	assert.Equal(t, "FROM alpine", fooReport["dockerfile"])
+	assert.Equal(t, "sha256:abc", fooReport["image_id"])
+	assert.Equal(t, []interface{}{"foo:latest"}, fooReport["tags"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"set": "last", "size": float64(3), "cached": true},
+	}, fooReport["layers"])
+	assert.Equal(t, true, fooReport["success"])
+	assert.NotContains(t, fooReport, "error")
+
+	barReport := actual["builds"][1]
+	assert.Equal(t, "bar", barReport["name"])
+	assert.Equal(t, "linux/arm64", barReport["platform"])
+	assert.Equal(t, []interface{}{}, barReport["tags"])
+	assert.NotContains(t, barReport, "image_id")
+	assert.Equal(t, false, barReport["success"])
+	assert.Equal(t, "failed", barReport["error"])
+}
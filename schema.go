@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+type SchemaOptions struct{}
+
+func init() {
+	var schemaOptions SchemaOptions
+
+	_, err := parser.AddCommand("schema", "Print JSON schema of the config", "Print JSON schema of the config file for editor integrations.", &schemaOptions)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (s *SchemaOptions) Execute(args []string) error {
+	data, err := json.MarshalIndent(ConfigSchema(), "", "  ")
+
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	fmt.Println(string(data))
+	return nil
+}
+
+type jsonSchema map[string]interface{}
+
+// schemaProvider is implemented by types whose schema can't be derived from
+// their fields, e.g. types accepting both strings and maps.
+type schemaProvider interface {
+	jsonSchema(g *schemaGenerator) jsonSchema
+}
+
+// ConfigSchema returns JSON schema of the config file generated from Config.
+func ConfigSchema() jsonSchema {
+	g := &schemaGenerator{
+		definitions: map[string]jsonSchema{},
+	}
+
+	schema := g.structSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = jsonSchemaDraft
+	schema["title"] = "Layercake config"
+	schema["definitions"] = g.definitions
+
+	return schema
+}
+
+type schemaGenerator struct {
+	definitions map[string]jsonSchema
+}
+
+// ref returns a reference to the definition of the named type.
+func (g *schemaGenerator) ref(t reflect.Type) jsonSchema {
+	name := strings.Title(t.Name())
+
+	if _, ok := g.definitions[name]; !ok {
+		// Add a placeholder first for recursive types
+		g.definitions[name] = nil
+		g.definitions[name] = g.typeSchema(t)
+	}
+
+	return jsonSchema{"$ref": "#/definitions/" + name}
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) jsonSchema {
+	if t.Name() != "" && (t.Kind() == reflect.Struct || reflect.PtrTo(t).Implements(schemaProviderType)) {
+		return g.ref(t)
+	}
+
+	return g.typeSchema(t)
+}
+
+// nolint: gochecknoglobals
+var schemaProviderType = reflect.TypeOf((*schemaProvider)(nil)).Elem()
+
+func (g *schemaGenerator) typeSchema(t reflect.Type) jsonSchema {
+	if p, ok := reflect.New(t).Interface().(schemaProvider); ok {
+		return p.jsonSchema(g)
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+
+	case reflect.Struct:
+		return g.structSchema(t)
+
+	case reflect.Map:
+		return jsonSchema{
+			"type":                 "object",
+			"additionalProperties": g.schema(t.Elem()),
+		}
+
+	case reflect.Slice, reflect.Array:
+		return jsonSchema{
+			"type":  "array",
+			"items": g.schema(t.Elem()),
+		}
+
+	case reflect.String:
+		// Scalars are decoded into strings
+		return jsonSchema{"type": []string{"string", "number", "boolean"}}
+
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	}
+
+	return jsonSchema{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) jsonSchema {
+	properties := map[string]jsonSchema{}
+
+	for name, index := range yamlFields(t) {
+		properties[name] = g.schema(t.FieldByIndex(index).Type)
+	}
+
+	return jsonSchema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func (BuildScript) jsonSchema(g *schemaGenerator) jsonSchema {
+	return jsonSchema{
+		"oneOf": []jsonSchema{
+			{
+				"type":        "string",
+				"description": "Dockerfile instruction",
+			},
+			{
+				"type": "object",
+				"properties": map[string]jsonSchema{
+					"import": {
+						"oneOf": []jsonSchema{
+							{
+								"type":        "string",
+								"description": "Name of the imported build",
+							},
+							g.ref(reflect.TypeOf(importScript{})),
+						},
+					},
+				},
+				"required":             []string{"import"},
+				"additionalProperties": false,
+			},
+			{
+				"type":        "object",
+				"description": "Dockerfile instruction and its value",
+				"propertyNames": jsonSchema{
+					"pattern": "^[A-Za-z]+$",
+					"not":     jsonSchema{"enum": []string{"import", "IMPORT", "Import"}},
+				},
+				"minProperties": 1,
+				"maxProperties": 1,
+			},
+			{
+				"type":        "object",
+				"description": "RUN instruction with mounts",
+				"properties": map[string]jsonSchema{
+					"run": {"type": []string{"string", "number", "boolean"}},
+					"mount": {
+						"oneOf": []jsonSchema{
+							mountSchema,
+							{"type": "array", "items": mountSchema},
+						},
+					},
+				},
+				"required":             []string{"run", "mount"},
+				"additionalProperties": false,
+			},
+		},
+	}
+}
+
+// nolint: gochecknoglobals
+var mountSchema = jsonSchema{
+	"oneOf": []jsonSchema{
+		{
+			"type":        "string",
+			"description": "Value of the --mount flag, e.g. type=secret,id=npmrc",
+		},
+		{
+			"type":                 "object",
+			"description":          "Options of the mount",
+			"properties":           map[string]jsonSchema{"type": {"type": "string"}},
+			"additionalProperties": jsonSchema{"type": []string{"string", "number", "boolean"}},
+		},
+	},
+}
+
+func (importScript) jsonSchema(g *schemaGenerator) jsonSchema {
+	schema := g.structSchema(reflect.TypeOf(importScript{}))
+	schema["required"] = []string{"from"}
+	schema["properties"].(map[string]jsonSchema)["layers"] = jsonSchema{
+		"type": "string",
+		"enum": layerSets,
+	}
+
+	return schema
+}
+
+func (BuildExtends) jsonSchema(g *schemaGenerator) jsonSchema {
+	type rawBuildExtends BuildExtends
+
+	schema := g.structSchema(reflect.TypeOf(rawBuildExtends{}))
+	schema["required"] = []string{"name"}
+	properties := schema["properties"].(map[string]jsonSchema)
+
+	for name := range properties {
+		if name != "name" {
+			properties[name] = jsonSchema{
+				"type": "string",
+				"enum": listMergeModes,
+			}
+		}
+	}
+
+	return jsonSchema{
+		"oneOf": []jsonSchema{
+			{
+				"type":        "string",
+				"description": "Name of the parent build or template",
+			},
+			schema,
+		},
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigSchema(t *testing.T) {
+	schema := ConfigSchema()
+	definitions := schema["definitions"].(map[string]jsonSchema)
+
+	t.Run("Root", func(t *testing.T) {
+		assert.Equal(t, jsonSchemaDraft, schema["$schema"])
+		assert.Equal(t, "object", schema["type"])
+		assert.Equal(t, false, schema["additionalProperties"])
+		assert.Equal(t, map[string]jsonSchema{
+			"build": {
+				"type":                 "object",
+				"additionalProperties": jsonSchema{"$ref": "#/definitions/BuildConfig"},
+			},
+			"templates": {
+				"type":                 "object",
+				"additionalProperties": jsonSchema{"$ref": "#/definitions/BuildConfig"},
+			},
+			"variables": {
+				"type":                 "object",
+				"additionalProperties": jsonSchema{"type": []string{"string", "number", "boolean"}},
+			},
+			"include": {
+				"type":  "array",
+				"items": jsonSchema{"type": []string{"string", "number", "boolean"}},
+			},
+		}, schema["properties"])
+	})
+
+	t.Run("BuildConfig", func(t *testing.T) {
+		properties := definitions["BuildConfig"]["properties"].(map[string]jsonSchema)
+
+		assert.ElementsMatch(t, []string{
+			"extends", "from", "tags", "args", "scripts", "cache_from", "labels", "inputs", "matrix", "platforms", "secrets",
+		}, schemaKeys(properties))
+		assert.Equal(t, jsonSchema{"$ref": "#/definitions/BuildExtends"}, properties["extends"])
+		assert.Equal(t, jsonSchema{"$ref": "#/definitions/BuildScript"}, properties["scripts"]["items"])
+	})
+
+	t.Run("BuildScript", func(t *testing.T) {
+		oneOf := definitions["BuildScript"]["oneOf"].([]jsonSchema)
+
+		require.Len(t, oneOf, 4)
+		assert.Equal(t, "string", oneOf[0]["type"])
+		assert.Equal(t, []string{"import"}, oneOf[1]["required"])
+		assert.Equal(t, 1, oneOf[2]["maxProperties"])
+		assert.Equal(t, []string{"run", "mount"}, oneOf[3]["required"])
+	})
+
+	t.Run("ImportScript", func(t *testing.T) {
+		schema := definitions["ImportScript"]
+		properties := schema["properties"].(map[string]jsonSchema)
+
+		assert.ElementsMatch(t, []string{"from", "layers", "paths", "to"}, schemaKeys(properties))
+		assert.Equal(t, []string{"from"}, schema["required"])
+		assert.Equal(t, layerSets, properties["layers"]["enum"])
+	})
+
+	t.Run("BuildExtends", func(t *testing.T) {
+		oneOf := definitions["BuildExtends"]["oneOf"].([]jsonSchema)
+
+		require.Len(t, oneOf, 2)
+		assert.Equal(t, "string", oneOf[0]["type"])
+
+		properties := oneOf[1]["properties"].(map[string]jsonSchema)
+
+		assert.ElementsMatch(t, []string{"name", "scripts", "cache_from", "inputs"}, schemaKeys(properties))
+		assert.Equal(t, listMergeModes, properties["scripts"]["enum"])
+		assert.Equal(t, []string{"name"}, oneOf[1]["required"])
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		_, err := json.Marshal(schema)
+		assert.NoError(t, err)
+	})
+}
+
+func schemaKeys(m map[string]jsonSchema) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	return
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/csv"
+	"path/filepath"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+// SSHSource is an SSH agent socket or private keys forwarded to builds. The
+// SSH_AUTH_SOCK socket is used if paths are empty.
+type SSHSource struct {
+	ID    string
+	Paths []string
+}
+
+// parseSecretFlags returns paths of secret files by their IDs in values of the
+// --secret option, e.g. id=npmrc,src=.npmrc. Relative paths are resolved from
+// dir.
+func parseSecretFlags(dir string, values []string) (map[string]string, error) {
+	result := map[string]string{}
+
+	for _, value := range values {
+		fields, err := csv.NewReader(strings.NewReader(value)).Read()
+
+		if err != nil {
+			return nil, merry.Errorf("invalid secret %q: %s", value, err)
+		}
+
+		var id, src string
+
+		for _, field := range fields {
+			parts := strings.SplitN(field, "=", 2)
+
+			if len(parts) != 2 {
+				return nil, merry.Errorf("invalid secret %q, options must be in the format of key=value", value)
+			}
+
+			switch strings.ToLower(parts[0]) {
+			case "type":
+				if parts[1] != "file" {
+					return nil, merry.Errorf("unsupported secret type %q", parts[1])
+				}
+
+			case "id":
+				id = parts[1]
+
+			case "src", "source":
+				src = parts[1]
+
+			default:
+				return nil, merry.Errorf("unexpected key %q in secret %q", parts[0], value)
+			}
+		}
+
+		if id == "" || src == "" {
+			return nil, merry.Errorf("invalid secret %q, it must be in the format of id=<id>,src=<path>", value)
+		}
+
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(dir, src)
+		}
+
+		result[id] = src
+	}
+
+	return result, nil
+}
+
+// parseSSHFlags parses values of the --ssh option in the format of
+// default|<id>[=<socket>|<key>[,<key>]]. Relative paths are resolved from dir.
+func parseSSHFlags(dir string, values []string) ([]SSHSource, error) {
+	var result []SSHSource
+
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		source := SSHSource{ID: parts[0]}
+
+		if source.ID == "" {
+			return nil, merry.Errorf("invalid SSH option %q, it must be in the format of default|<id>[=<socket>|<key>[,<key>]]", value)
+		}
+
+		if len(parts) == 2 {
+			for _, path := range strings.Split(parts[1], ",") {
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(dir, path)
+				}
+
+				source.Paths = append(source.Paths, path)
+			}
+		}
+
+		result = append(result, source)
+	}
+
+	return result, nil
+}
+
+// buildSecrets returns paths of secrets declared in the build. All of them must
+// be provided with the --secret option.
+func buildSecrets(name string, build *BuildConfig, provided map[string]string) (map[string]string, error) {
+	if len(build.Secrets) == 0 {
+		return nil, nil
+	}
+
+	result := map[string]string{}
+
+	for _, id := range build.Secrets {
+		src, ok := provided[id]
+
+		if !ok {
+			return nil, merry.Errorf("build %q requires secret %q, which must be provided with --secret id=%s,src=<path>", name, id, id)
+		}
+
+		result[id] = src
+	}
+
+	return result, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSecretFlags(t *testing.T) {
+	dir := filepath.FromSlash("/home/foo")
+
+	t.Run("Success", func(t *testing.T) {
+		actual, err := parseSecretFlags(dir, []string{
+			"id=npmrc,src=.npmrc",
+			"type=file,id=netrc,source=/etc/netrc",
+			`id=key,"src=a,b"`,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"npmrc": filepath.Join(dir, ".npmrc"),
+			"netrc": "/etc/netrc",
+			"key":   filepath.Join(dir, "a,b"),
+		}, actual)
+	})
+
+	errorTests := []struct {
+		Name  string
+		Input string
+	}{
+		{Name: "No ID", Input: "src=.npmrc"},
+		{Name: "No source", Input: "id=npmrc"},
+		{Name: "Not a key-value pair", Input: "npmrc"},
+		{Name: "Unknown key", Input: "id=npmrc,src=.npmrc,foo=bar"},
+		{Name: "Unsupported type", Input: "type=env,id=npmrc,src=NPMRC"},
+	}
+
+	for _, test := range errorTests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			_, err := parseSecretFlags(dir, []string{test.Input})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseSSHFlags(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		actual, err := parseSSHFlags("/home/foo", []string{"default", "github=/tmp/agent.sock", "keys=.ssh/id_rsa,/keys/id_ed25519"})
+		require.NoError(t, err)
+		assert.Equal(t, []SSHSource{
+			{ID: "default"},
+			{ID: "github", Paths: []string{"/tmp/agent.sock"}},
+			{ID: "keys", Paths: []string{"/home/foo/.ssh/id_rsa", "/keys/id_ed25519"}},
+		}, actual)
+	})
+
+	t.Run("No ID", func(t *testing.T) {
+		_, err := parseSSHFlags("/home/foo", []string{"=/tmp/agent.sock"})
+		assert.Error(t, err)
+	})
+}
+
+func TestBuildSecrets(t *testing.T) {
+	provided := map[string]string{
+		"npmrc": "/home/foo/.npmrc",
+		"netrc": "/home/foo/.netrc",
+	}
+
+	t.Run("No secrets", func(t *testing.T) {
+		actual, err := buildSecrets("foo", &BuildConfig{}, provided)
+		require.NoError(t, err)
+		assert.Nil(t, actual)
+	})
+
+	t.Run("Declared secrets only", func(t *testing.T) {
+		actual, err := buildSecrets("foo", &BuildConfig{Secrets: []string{"npmrc"}}, provided)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"npmrc": "/home/foo/.npmrc"}, actual)
+	})
+
+	t.Run("Not provided", func(t *testing.T) {
+		_, err := buildSecrets("foo", &BuildConfig{Secrets: []string{"token"}}, provided)
+		assert.EqualError(t, err, `build "foo" requires secret "token", which must be provided with --secret id=token,src=<path>`)
+	})
+}
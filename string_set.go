@@ -1,5 +1,7 @@
 package main
 
+import "sort"
+
 type StringSet map[string]bool
 
 func NewStringSet() StringSet {
@@ -41,6 +43,12 @@ func (s StringSet) Slice() (result []string) {
 	return
 }
 
+func (s StringSet) SortedSlice() []string {
+	result := s.Slice()
+	sort.Strings(result)
+	return result
+}
+
 type OrderedStringSet struct {
 	arr []string
 	m   map[string]int
@@ -78,6 +78,12 @@ func TestStringSet_Slice(t *testing.T) {
 	assert.ElementsMatch(t, values, set.Slice())
 }
 
+func TestStringSet_SortedSlice(t *testing.T) {
+	set := NewStringSet()
+	set.Insert("c", "a", "b")
+	assert.Equal(t, []string{"a", "b", "c"}, set.SortedSlice())
+}
+
 func assertOrderedStringSet(t *testing.T, expected []string, set *OrderedStringSet) {
 	assert.Equal(t, expected, set.Slice())
 
@@ -1,5 +1,10 @@
 package main
 
+import (
+	"fmt"
+	"strings"
+)
+
 func RunSeries(fns ...func() error) (err error) {
 	for _, fn := range fns {
 		if err = fn(); err != nil {
@@ -9,3 +14,97 @@ func RunSeries(fns ...func() error) (err error) {
 
 	return
 }
+
+func stringSliceContains(slice []string, value string) bool {
+	return stringSliceIndex(slice, value) >= 0
+}
+
+func stringSliceIndex(slice []string, value string) int {
+	for i, s := range slice {
+		if s == value {
+			return i
+		}
+	}
+
+	return -1
+}
+
+type graphResult struct {
+	node string
+	err  error
+}
+
+// RunGraph calls fn for each node after all of its dependencies are done. At
+// most parallel calls run at the same time. Dependencies which are not in nodes
+// are ignored. It stops scheduling new nodes once a call failed and returns the
+// first error after all running calls are finished.
+func RunGraph(nodes []string, deps func(node string) StringSet, parallel int, fn func(node string) error) (err error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	all := NewStringSet()
+	all.Insert(nodes...)
+
+	pending := append([]string{}, nodes...)
+	done := NewStringSet()
+	results := make(chan graphResult)
+	running := 0
+
+	isReady := func(node string) bool {
+		ready := true
+
+		deps(node).Range(func(dep string) bool {
+			if all.Contains(dep) && !done.Contains(dep) {
+				ready = false
+				return false
+			}
+
+			return true
+		})
+
+		return ready
+	}
+
+	for {
+		if err == nil {
+			var waiting []string
+
+			for _, node := range pending {
+				if running >= parallel || !isReady(node) {
+					waiting = append(waiting, node)
+					continue
+				}
+
+				running++
+
+				go func(node string) {
+					results <- graphResult{node: node, err: fn(node)}
+				}(node)
+			}
+
+			pending = waiting
+		}
+
+		if running == 0 {
+			break
+		}
+
+		res := <-results
+		running--
+
+		if res.err != nil {
+			if err == nil {
+				err = res.err
+			}
+		} else {
+			done.Insert(res.node)
+		}
+	}
+
+	if err == nil && len(pending) > 0 {
+		err = fmt.Errorf("unable to resolve dependencies of %s", strings.Join(pending, ", "))
+	}
+
+	return
+}
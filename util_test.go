@@ -2,7 +2,9 @@ package main
 
 import (
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -49,3 +51,102 @@ func TestRunSeries(t *testing.T) {
 		assert.Equal(t, []int{1, 2}, nums)
 	})
 }
+
+func TestStringSliceContains(t *testing.T) {
+	slice := []string{"a", "b"}
+	assert.True(t, stringSliceContains(slice, "a"))
+	assert.False(t, stringSliceContains(slice, "c"))
+	assert.False(t, stringSliceContains(nil, "a"))
+}
+
+func TestRunGraph(t *testing.T) {
+	graph := map[string][]string{
+		"a": {},
+		"b": {},
+		"c": {"a", "b"},
+		"d": {"c", "e"},
+	}
+	nodes := []string{"a", "b", "c", "d"}
+	deps := func(node string) StringSet {
+		set := NewStringSet()
+		set.Insert(graph[node]...)
+		return set
+	}
+
+	t.Run("Sequential", func(t *testing.T) {
+		var actual []string
+		err := RunGraph(nodes, deps, 1, func(node string) error {
+			actual = append(actual, node)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, nodes, actual)
+	})
+
+	t.Run("Parallel", func(t *testing.T) {
+		var (
+			lock     sync.Mutex
+			finished = NewStringSet()
+			running  int
+			maxCount int
+		)
+
+		err := RunGraph(nodes, deps, 2, func(node string) error {
+			lock.Lock()
+			running++
+
+			if running > maxCount {
+				maxCount = running
+			}
+
+			for _, dep := range graph[node] {
+				assert.True(t, finished.Contains(dep) || dep == "e", "%s should finish before %s", dep, node)
+			}
+
+			lock.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			lock.Lock()
+			running--
+			finished.Insert(node)
+			lock.Unlock()
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, maxCount)
+		assertStringSet(t, nodes, finished)
+	})
+
+	t.Run("Interrupt", func(t *testing.T) {
+		var actual []string
+		returnErr := errors.New("test")
+		err := RunGraph(nodes, deps, 1, func(node string) error {
+			actual = append(actual, node)
+
+			if node == "b" {
+				return returnErr
+			}
+
+			return nil
+		})
+		assert.Equal(t, returnErr, err)
+		assert.Equal(t, []string{"a", "b"}, actual)
+	})
+
+	t.Run("Unresolvable", func(t *testing.T) {
+		err := RunGraph([]string{"a", "b"}, func(node string) StringSet {
+			set := NewStringSet()
+
+			if node == "a" {
+				set.Insert("b")
+			} else {
+				set.Insert("a")
+			}
+
+			return set
+		}, 1, func(node string) error {
+			return nil
+		})
+		assert.Error(t, err)
+	})
+}
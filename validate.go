@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/docker/distribution/reference"
+)
+
+const (
+	validateFormatText = "text"
+	validateFormatJSON = "json"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+const (
+	ruleConfig              = "config"
+	ruleUnknownInstruction  = "unknown-instruction"
+	ruleImportEmptyLayer    = "import-empty-layer"
+	ruleUnusedBuild         = "unused-build"
+	ruleInvalidBaseImage    = "invalid-base-image"
+	ruleLatestBaseImage     = "latest-base-image"
+	ruleDuplicateTag        = "duplicate-tag"
+	ruleMissingDockerignore = "missing-dockerignore"
+)
+
+// nolint: gochecknoglobals
+var (
+	dockerfileInstructions = []string{
+		"ADD", "ARG", "CMD", "COPY", "ENTRYPOINT", "ENV", "EXPOSE", "HEALTHCHECK",
+		"LABEL", "MAINTAINER", "ONBUILD", "RUN", "SHELL", "STOPSIGNAL", "USER",
+		"VOLUME", "WORKDIR",
+	}
+
+	// Instructions which add a layer to the file system
+	layerInstructions = []string{"ADD", "COPY", "RUN"}
+)
+
+type ValidateOptions struct {
+	Format string `long:"format" short:"f" description:"Output format" choice:"text" choice:"json" default:"text"`
+}
+
+// ValidationIssue is a problem found in the config.
+type ValidationIssue struct {
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Message  string `json:"message"`
+}
+
+func newValidationIssue(severity, rule string, pos Position, format string, args ...interface{}) ValidationIssue {
+	return ValidationIssue{
+		Severity: severity,
+		Rule:     rule,
+		File:     pos.File,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+func (v ValidationIssue) String() string {
+	result := fmt.Sprintf("%s: %s [%s]", v.Severity, v.Message, v.Rule)
+	pos := Position{File: v.File, Line: v.Line, Column: v.Column}
+
+	if s := pos.String(); s != "" {
+		result = s + ": " + result
+	}
+
+	return result
+}
+
+func init() {
+	var validateOptions ValidateOptions
+
+	_, err := parser.AddCommand("validate", "Validate the config", "Check the config for errors and common mistakes without building images.", &validateOptions)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (v *ValidateOptions) Execute(args []string) error {
+	var issues []ValidationIssue
+
+	config, err := InitConfig()
+
+	if errs, ok := err.(ConfigErrors); ok {
+		for _, e := range errs {
+			issues = append(issues, newValidationIssue(SeverityError, ruleConfig, e.Position, "%s", e.Message))
+		}
+	} else if err != nil {
+		return merry.Wrap(err)
+	} else {
+		issues = ValidateConfig(config, cwd)
+	}
+
+	if err := v.printIssues(issues); err != nil {
+		return merry.Wrap(err)
+	}
+
+	errCount := 0
+
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			errCount++
+		}
+	}
+
+	if errCount > 0 {
+		return merry.Errorf("found %d errors in the config", errCount)
+	}
+
+	return nil
+}
+
+func (v *ValidateOptions) printIssues(issues []ValidationIssue) error {
+	if v.Format == validateFormatJSON {
+		if issues == nil {
+			issues = []ValidationIssue{}
+		}
+
+		data, err := json.MarshalIndent(issues, "", "  ")
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(issues) == 0 {
+		fmt.Println("No issues found")
+		return nil
+	}
+
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+
+	return nil
+}
+
+// ValidateConfig runs extended checks on the config. Dir is the path of the
+// build context.
+func ValidateConfig(config *Config, dir string) []ValidationIssue {
+	var issues []ValidationIssue
+
+	for _, name := range config.BuildNames() {
+		build := config.Build[name]
+
+		issues = append(issues, validateInstructions(name, build)...)
+		issues = append(issues, validateImports(config, name, build)...)
+		issues = append(issues, validateBaseImage(name, build)...)
+
+		if len(build.Tags) == 0 && config.FindDependants(name).Len() == 0 {
+			issues = append(issues, newValidationIssue(SeverityWarning, ruleUnusedBuild, build.position(""),
+				"build %q has no tags and is not imported by other builds", name))
+		}
+	}
+
+	issues = append(issues, validateTags(config)...)
+
+	if _, err := os.Stat(filepath.Join(dir, ".dockerignore")); os.IsNotExist(err) {
+		issues = append(issues, newValidationIssue(SeverityWarning, ruleMissingDockerignore, Position{},
+			".dockerignore is missing, so the whole directory is sent to Docker"))
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		a, b := issues[i], issues[j]
+
+		if a.File != b.File {
+			return a.File < b.File
+		}
+
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+
+		return a.Column < b.Column
+	})
+
+	return issues
+}
+
+func validateInstructions(name string, build BuildConfig) (issues []ValidationIssue) {
+	for _, script := range build.Scripts {
+		instruction := script.instruction()
+
+		switch {
+		case instruction == "":
+			continue
+
+		case instruction == "FROM":
+			issues = append(issues, newValidationIssue(SeverityError, ruleUnknownInstruction, script.pos,
+				"build %q contains FROM instruction in scripts, use from instead", name))
+
+		case !stringSliceContains(dockerfileInstructions, instruction):
+			issues = append(issues, newValidationIssue(SeverityError, ruleUnknownInstruction, script.pos,
+				"build %q contains unknown instruction %q", name, instruction))
+		}
+	}
+
+	return
+}
+
+// validateImports checks whether the last layers of imported builds are
+// created by their last scripts. Instructions like ENV don't create layers, so
+// the imported layer would be created by an earlier script or the base image.
+func validateImports(config *Config, name string, build BuildConfig) (issues []ValidationIssue) {
+	for _, script := range build.Scripts {
+		if script.Import == "" || script.ImportOptions.LayerSet() != LayerSetLast {
+			continue
+		}
+
+		imported, ok := config.Build[script.Import]
+
+		if !ok {
+			continue
+		}
+
+		lastLayer := -1
+
+		for i, s := range imported.Scripts {
+			if stringSliceContains(layerInstructions, s.instruction()) {
+				lastLayer = i
+			}
+		}
+
+		switch {
+		case lastLayer < 0:
+			issues = append(issues, newValidationIssue(SeverityError, ruleImportEmptyLayer, script.pos,
+				"build %q imports the last layer of build %q, which has no scripts creating layers, so the last layer of the base image is imported", name, script.Import))
+
+		case lastLayer < len(imported.Scripts)-1:
+			issues = append(issues, newValidationIssue(SeverityWarning, ruleImportEmptyLayer, script.pos,
+				"build %q imports the last layer of build %q, whose last script doesn't create a layer", name, script.Import))
+		}
+	}
+
+	return
+}
+
+func validateBaseImage(name string, build BuildConfig) []ValidationIssue {
+	if build.From == "" || build.From == "scratch" {
+		return nil
+	}
+
+	ref, err := reference.ParseNormalizedNamed(build.From)
+
+	if err != nil {
+		return []ValidationIssue{
+			newValidationIssue(SeverityError, ruleInvalidBaseImage, build.position("from"),
+				"build %q has invalid base image %q: %s", name, build.From, err),
+		}
+	}
+
+	if _, ok := ref.(reference.Digested); ok {
+		return nil
+	}
+
+	if tagged, ok := ref.(reference.Tagged); ok && tagged.Tag() != "latest" {
+		return nil
+	}
+
+	return []ValidationIssue{
+		newValidationIssue(SeverityWarning, ruleLatestBaseImage, build.position("from"),
+			"build %q uses the latest tag of base image %q, which makes builds unreproducible", name, build.From),
+	}
+}
+
+func validateTags(config *Config) (issues []ValidationIssue) {
+	builds := map[string][]string{}
+
+	for _, name := range config.BuildNames() {
+		seen := NewStringSet()
+
+		for _, tag := range config.Build[name].Tags {
+			key := normalizeTag(tag)
+
+			if !seen.Contains(key) {
+				seen.Insert(key)
+				builds[key] = append(builds[key], name)
+			}
+		}
+	}
+
+	for _, name := range config.BuildNames() {
+		build := config.Build[name]
+
+		for _, tag := range build.Tags {
+			var others []string
+
+			for _, other := range builds[normalizeTag(tag)] {
+				if other != name {
+					others = append(others, other)
+				}
+			}
+
+			if len(others) > 0 {
+				issues = append(issues, newValidationIssue(SeverityError, ruleDuplicateTag, build.position("tags"),
+					"tag %q of build %q is also used by %s", tag, name, strings.Join(others, ", ")))
+			}
+		}
+	}
+
+	return
+}
+
+// normalizeTag returns the full name of the tag, so "foo" and
+// "docker.io/library/foo:latest" are the same.
+func normalizeTag(tag string) string {
+	ref, err := reference.ParseNormalizedNamed(tag)
+
+	if err != nil {
+		return tag
+	}
+
+	return reference.TagNameOnly(ref).String()
+}
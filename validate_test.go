@@ -0,0 +1,256 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidationIssue_String(t *testing.T) {
+	t.Run("With position", func(t *testing.T) {
+		issue := newValidationIssue(SeverityError, ruleConfig, Position{File: "a.yml", Line: 1, Column: 2}, "foo")
+		assert.Equal(t, "a.yml:1:2: error: foo [config]", issue.String())
+	})
+
+	t.Run("Without position", func(t *testing.T) {
+		issue := newValidationIssue(SeverityWarning, ruleMissingDockerignore, Position{}, "foo")
+		assert.Equal(t, "warning: foo [missing-dockerignore]", issue.String())
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layercake")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	validate := func(t *testing.T, input string) []ValidationIssue {
+		config, err := LoadConfig([]byte(normalizeYAMLString(input)))
+		require.NoError(t, err)
+		require.NoError(t, config.Validate())
+
+		return ValidateConfig(config, dir)
+	}
+
+	t.Run("Missing .dockerignore", func(t *testing.T) {
+		assert.Equal(t, []ValidationIssue{
+			{
+				Severity: SeverityWarning,
+				Rule:     ruleMissingDockerignore,
+				Message:  ".dockerignore is missing, so the whole directory is sent to Docker",
+			},
+		}, validate(t, "build: {}"))
+	})
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".dockerignore"), []byte(".git"), os.ModePerm))
+
+	tests := []struct {
+		Name     string
+		Input    string
+		Expected []ValidationIssue
+	}{
+		{
+			Name: "Valid",
+			Input: `
+build:
+	foo:
+		from: alpine:3.9
+		tags: [foo]
+		scripts:
+			- import: bar
+	bar:
+		from: alpine@sha256:769fddc7cc2f0a1c35abb2f91432e8beecf83916c421420e6a6da9f8975464b6
+		scripts:
+			- RUN echo bar
+			- "# comment"
+			- add: [bar, /bar]
+`,
+		},
+		{
+			Name: "Unknown instruction",
+			Input: `
+build:
+	foo:
+		from: alpine:3.9
+		tags: [foo]
+		scripts:
+			- RUM echo foo
+			- foo: bar
+			- from: alpine
+`,
+			Expected: []ValidationIssue{
+				{
+					Severity: SeverityError,
+					Rule:     ruleUnknownInstruction,
+					Line:     6,
+					Column:   9,
+					Message:  `build "foo" contains unknown instruction "RUM"`,
+				},
+				{
+					Severity: SeverityError,
+					Rule:     ruleUnknownInstruction,
+					Line:     7,
+					Column:   9,
+					Message:  `build "foo" contains unknown instruction "FOO"`,
+				},
+				{
+					Severity: SeverityError,
+					Rule:     ruleUnknownInstruction,
+					Line:     8,
+					Column:   9,
+					Message:  `build "foo" contains FROM instruction in scripts, use from instead`,
+				},
+			},
+		},
+		{
+			Name: "Import empty layer",
+			Input: `
+build:
+	foo:
+		from: alpine:3.9
+		tags: [foo]
+		scripts:
+			- import: bar
+			- import: baz
+			- import: {from: baz, layers: all}
+	bar:
+		from: alpine:3.9
+		scripts:
+			- RUN echo bar
+			- ENV FOO=bar
+	baz:
+		from: alpine:3.9
+		scripts:
+			- ENV FOO=baz
+`,
+			Expected: []ValidationIssue{
+				{
+					Severity: SeverityWarning,
+					Rule:     ruleImportEmptyLayer,
+					Line:     6,
+					Column:   9,
+					Message:  `build "foo" imports the last layer of build "bar", whose last script doesn't create a layer`,
+				},
+				{
+					Severity: SeverityError,
+					Rule:     ruleImportEmptyLayer,
+					Line:     7,
+					Column:   9,
+					Message:  `build "foo" imports the last layer of build "baz", which has no scripts creating layers, so the last layer of the base image is imported`,
+				},
+			},
+		},
+		{
+			Name: "Unused build",
+			Input: `
+build:
+	foo:
+		from: alpine:3.9
+`,
+			Expected: []ValidationIssue{
+				{
+					Severity: SeverityWarning,
+					Rule:     ruleUnusedBuild,
+					Line:     2,
+					Column:   3,
+					Message:  `build "foo" has no tags and is not imported by other builds`,
+				},
+			},
+		},
+		{
+			Name: "Latest base image",
+			Input: `
+build:
+	foo:
+		from: alpine
+		tags: [foo]
+	bar:
+		from: alpine:latest
+		tags: [bar]
+	baz:
+		from: scratch
+		tags: [baz]
+`,
+			Expected: []ValidationIssue{
+				{
+					Severity: SeverityWarning,
+					Rule:     ruleLatestBaseImage,
+					Line:     3,
+					Column:   11,
+					Message:  `build "foo" uses the latest tag of base image "alpine", which makes builds unreproducible`,
+				},
+				{
+					Severity: SeverityWarning,
+					Rule:     ruleLatestBaseImage,
+					Line:     6,
+					Column:   11,
+					Message:  `build "bar" uses the latest tag of base image "alpine:latest", which makes builds unreproducible`,
+				},
+			},
+		},
+		{
+			Name: "Invalid base image",
+			Input: `
+build:
+	foo:
+		from: Alpine:3.9
+		tags: [foo]
+`,
+			Expected: []ValidationIssue{
+				{
+					Severity: SeverityError,
+					Rule:     ruleInvalidBaseImage,
+					Line:     3,
+					Column:   11,
+					Message:  `build "foo" has invalid base image "Alpine:3.9": invalid reference format: repository name must be lowercase`,
+				},
+			},
+		},
+		{
+			Name: "Duplicate tag",
+			Input: `
+build:
+	foo:
+		from: alpine:3.9
+		tags: [foo, foo:latest]
+	bar:
+		from: alpine:3.9
+		tags: [docker.io/library/foo]
+`,
+			Expected: []ValidationIssue{
+				{
+					Severity: SeverityError,
+					Rule:     ruleDuplicateTag,
+					Line:     4,
+					Column:   11,
+					Message:  `tag "foo" of build "foo" is also used by bar`,
+				},
+				{
+					Severity: SeverityError,
+					Rule:     ruleDuplicateTag,
+					Line:     4,
+					Column:   11,
+					Message:  `tag "foo:latest" of build "foo" is also used by bar`,
+				},
+				{
+					Severity: SeverityError,
+					Rule:     ruleDuplicateTag,
+					Line:     7,
+					Column:   11,
+					Message:  `tag "docker.io/library/foo" of build "bar" is also used by foo`,
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, validate(t, test.Input))
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+const (
+	// Whiteout files are used by Docker to represent files deleted in a layer.
+	// See: https://github.com/opencontainers/image-spec/blob/master/layer.md#whiteouts
+	whiteoutPrefix     = ".wh."
+	whiteoutMetaPrefix = whiteoutPrefix + whiteoutPrefix
+	whiteoutOpaqueDir  = whiteoutMetaPrefix + ".opq"
+)
+
+// Whiteout represents a file or a directory deleted in a layer.
+type Whiteout struct {
+	// Path of the deleted file. If Opaque is true, it's the path of the
+	// directory whose children are deleted.
+	Path string
+
+	// Opaque indicates that all children of the directory in lower layers are
+	// deleted.
+	Opaque bool
+}
+
+// ParseWhiteout returns the whiteout represented by the tar entry name. The
+// second return value is false if the entry is not a whiteout file. AUFS
+// metadata files are returned as whiteouts with an empty path.
+func ParseWhiteout(name string) (Whiteout, bool) {
+	dir, base := path.Split(path.Clean(name))
+
+	// Files in AUFS metadata directories
+	for _, part := range strings.Split(dir, "/") {
+		if strings.HasPrefix(part, whiteoutMetaPrefix) {
+			return Whiteout{}, true
+		}
+	}
+
+	if !strings.HasPrefix(base, whiteoutPrefix) {
+		return Whiteout{}, false
+	}
+
+	if base == whiteoutOpaqueDir {
+		return Whiteout{Path: path.Clean(dir), Opaque: true}, true
+	}
+
+	if strings.HasPrefix(base, whiteoutMetaPrefix) {
+		return Whiteout{}, true
+	}
+
+	return Whiteout{Path: path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))}, true
+}
+
+// StripWhiteouts copies the layer tar from r to w without whiteout files.
+//
+// Imported layers are extracted with ADD, which writes whiteout files as
+// regular files. Whiteouts only delete files from lower layers of the image
+// where the layer is exported, which are not imported, so it's safe to discard
+// them. The returned whiteouts are for information only.
+func StripWhiteouts(r io.Reader, w io.Writer) ([]Whiteout, error) {
+	var whiteouts []Whiteout
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+
+		if wh, ok := ParseWhiteout(header.Name); ok {
+			if wh.Path != "" {
+				whiteouts = append(whiteouts, wh)
+			}
+
+			continue
+		}
+
+		if _, err := TarAddFile(tw, header, tr); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	return whiteouts, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestTar(t *testing.T, files []tarFile) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for _, file := range files {
+		header := &tar.Header{
+			Name: file.Name,
+			Size: int64(len(file.Data)),
+			Mode: 0644,
+		}
+
+		_, err := TarAddFile(tw, header, bytes.NewReader(file.Data))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tw.Close())
+	return &buf
+}
+
+func TestParseWhiteout(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Input    string
+		Expected Whiteout
+		OK       bool
+	}{
+		{
+			Name:  "Regular file",
+			Input: "usr/bin/foo",
+		},
+		{
+			Name:     "Regular whiteout",
+			Input:    "usr/bin/.wh.foo",
+			Expected: Whiteout{Path: "usr/bin/foo"},
+			OK:       true,
+		},
+		{
+			Name:     "Regular whiteout in root",
+			Input:    ".wh.foo",
+			Expected: Whiteout{Path: "foo"},
+			OK:       true,
+		},
+		{
+			Name:     "Opaque whiteout",
+			Input:    "usr/lib/.wh..wh..opq",
+			Expected: Whiteout{Path: "usr/lib", Opaque: true},
+			OK:       true,
+		},
+		{
+			Name:  "AUFS metadata",
+			Input: ".wh..wh.plnk/123.456",
+			OK:    true,
+		},
+		{
+			Name:  "AUFS metadata directory",
+			Input: ".wh..wh.plnk",
+			OK:    true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			actual, ok := ParseWhiteout(test.Input)
+			assert.Equal(t, test.OK, ok)
+			assert.Equal(t, test.Expected, actual)
+		})
+	}
+}
+
+func TestStripWhiteouts(t *testing.T) {
+	input := writeTestTar(t, []tarFile{
+		{Name: "usr/bin/foo", Data: []byte("foo")},
+		{Name: "usr/bin/.wh.bar", Data: []byte{}},
+		{Name: "usr/lib/.wh..wh..opq", Data: []byte{}},
+		{Name: "usr/lib/baz", Data: []byte("baz")},
+		{Name: ".wh..wh.aufs", Data: []byte{}},
+		{Name: ".wh..wh.plnk/123.456", Data: []byte("plnk")},
+	})
+
+	var output bytes.Buffer
+	whiteouts, err := StripWhiteouts(input, &output)
+	require.NoError(t, err)
+	assert.Equal(t, []Whiteout{
+		{Path: "usr/bin/bar"},
+		{Path: "usr/lib", Opaque: true},
+	}, whiteouts)
+
+	files, err := readTar(&output)
+	require.NoError(t, err)
+	assert.Equal(t, []tarFile{
+		{Name: "usr/bin/foo", Data: []byte("foo")},
+		{Name: "usr/lib/baz", Data: []byte("baz")},
+	}, files)
+}
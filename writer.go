@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// PrefixWriter prepends a prefix to every line written to the underlying
+// writer. Lines are buffered until a line break is written so that output of
+// several writers sharing the same lock doesn't interleave.
+type PrefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	lock   sync.Locker
+	buf    []byte
+}
+
+func NewPrefixWriter(w io.Writer, prefix string, lock sync.Locker) *PrefixWriter {
+	return &PrefixWriter{
+		w:      w,
+		prefix: []byte(prefix),
+		lock:   lock,
+	}
+}
+
+func (p *PrefixWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+
+	for {
+		idx := bytes.IndexByte(p.buf, '\n')
+
+		if idx < 0 {
+			break
+		}
+
+		if err := p.writeLine(p.buf[:idx+1]); err != nil {
+			return 0, err
+		}
+
+		p.buf = p.buf[idx+1:]
+	}
+
+	return len(data), nil
+}
+
+// Flush writes the remaining data which doesn't end with a line break.
+func (p *PrefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+
+	line := append(p.buf, '\n')
+	p.buf = nil
+
+	return p.writeLine(line)
+}
+
+func (p *PrefixWriter) writeLine(line []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, err := p.w.Write(p.prefix); err != nil {
+		return err
+	}
+
+	_, err := p.w.Write(line)
+	return err
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixWriter_Write(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewPrefixWriter(&buf, "foo: ", &sync.Mutex{})
+
+	n, err := w.Write([]byte("a\nb"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "foo: a\n", buf.String())
+
+	_, err = w.Write([]byte("c\nd\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "foo: a\nfoo: bc\nfoo: d\n", buf.String())
+}
+
+func TestPrefixWriter_Flush(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewPrefixWriter(&buf, "foo: ", &sync.Mutex{})
+
+	_, err := w.Write([]byte("a\nb"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "foo: a\nfoo: b\n", buf.String())
+
+	// Nothing to flush
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "foo: a\nfoo: b\n", buf.String())
+}
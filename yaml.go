@@ -0,0 +1,210 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// positioner is implemented by values which record their positions in config
+// files. The field is empty for the position of the value itself.
+type positioner interface {
+	setPosition(field string, pos Position)
+}
+
+// decodeYAML decodes the node into out, which must be a pointer. Unknown and
+// duplicated fields are not allowed. Unlike yaml.v3, it collects all errors
+// with their positions instead of stopping at the first error.
+func decodeYAML(file string, node *yaml.Node, out interface{}) error {
+	return decodeNode(file, node, reflect.ValueOf(out).Elem()).Err()
+}
+
+func decodeNode(file string, node *yaml.Node, out reflect.Value) (errs ConfigErrors) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+
+		return decodeNode(file, node.Content[0], out)
+
+	case yaml.AliasNode:
+		return decodeNode(file, node.Alias, out)
+	}
+
+	defer func() {
+		if p, ok := out.Addr().Interface().(positioner); ok {
+			p.setPosition("", nodePosition(file, node))
+		}
+	}()
+
+	if u, ok := out.Addr().Interface().(yaml.Unmarshaler); ok {
+		if err := u.UnmarshalYAML(node); err != nil {
+			return newConfigErrors(file, node, err)
+		}
+
+		return nil
+	}
+
+	if node.ShortTag() == "!!null" {
+		return nil
+	}
+
+	switch out.Kind() {
+	case reflect.Ptr:
+		value := reflect.New(out.Type().Elem())
+		errs = decodeNode(file, node, value.Elem())
+		out.Set(value)
+
+	case reflect.Struct:
+		errs = decodeStruct(file, node, out)
+
+	case reflect.Map:
+		errs = decodeMap(file, node, out)
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return ConfigErrors{newConfigError(nodePosition(file, node), "expected a list")}
+		}
+
+		slice := reflect.MakeSlice(out.Type(), 0, len(node.Content))
+
+		for _, item := range node.Content {
+			value := reflect.New(out.Type().Elem()).Elem()
+			errs = append(errs, decodeNode(file, item, value)...)
+			slice = reflect.Append(slice, value)
+		}
+
+		out.Set(slice)
+
+	default:
+		if node.Kind != yaml.ScalarNode {
+			return ConfigErrors{newConfigError(nodePosition(file, node), "expected a %s", out.Type())}
+		}
+
+		if err := node.Decode(out.Addr().Interface()); err != nil {
+			errs = newConfigErrors(file, node, err)
+		}
+	}
+
+	return
+}
+
+func decodeStruct(file string, node *yaml.Node, out reflect.Value) (errs ConfigErrors) {
+	if node.Kind != yaml.MappingNode {
+		return ConfigErrors{newConfigError(nodePosition(file, node), "expected a map")}
+	}
+
+	fields := yamlFields(out.Type())
+	decoded := NewStringSet()
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		if decoded.Contains(key.Value) {
+			errs = append(errs, newConfigError(nodePosition(file, key), "duplicated field %q", key.Value))
+			continue
+		}
+
+		decoded.Insert(key.Value)
+		index, ok := fields[key.Value]
+
+		if !ok {
+			errs = append(errs, newConfigError(nodePosition(file, key), "unknown field %q", key.Value))
+			continue
+		}
+
+		field := out.FieldByIndex(index)
+		errs = append(errs, decodeNode(file, value, field)...)
+
+		if p, ok := out.Addr().Interface().(positioner); ok {
+			p.setPosition(key.Value, nodePosition(file, value))
+
+			// Values in maps of scalars are recorded as "<field>.<key>"
+			if value.Kind == yaml.MappingNode && field.Kind() == reflect.Map && field.Type().Elem().Kind() != reflect.Struct {
+				for j := 0; j+1 < len(value.Content); j += 2 {
+					p.setPosition(key.Value+"."+value.Content[j].Value, nodePosition(file, value.Content[j+1]))
+				}
+			}
+		}
+	}
+
+	return
+}
+
+func decodeMap(file string, node *yaml.Node, out reflect.Value) (errs ConfigErrors) {
+	if node.Kind != yaml.MappingNode {
+		return ConfigErrors{newConfigError(nodePosition(file, node), "expected a map")}
+	}
+
+	if out.IsNil() {
+		out.Set(reflect.MakeMap(out.Type()))
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := reflect.New(out.Type().Key()).Elem()
+		keyErrs := decodeNode(file, node.Content[i], key)
+
+		if len(keyErrs) > 0 {
+			errs = append(errs, keyErrs...)
+			continue
+		}
+
+		if out.MapIndex(key).IsValid() {
+			errs = append(errs, newConfigError(nodePosition(file, node.Content[i]), "duplicated key %q", node.Content[i].Value))
+			continue
+		}
+
+		value := reflect.New(out.Type().Elem()).Elem()
+		errs = append(errs, decodeNode(file, node.Content[i+1], value)...)
+
+		// Values in maps are located by their keys
+		if p, ok := value.Addr().Interface().(positioner); ok {
+			p.setPosition("", nodePosition(file, node.Content[i]))
+		}
+
+		out.SetMapIndex(key, value)
+	}
+
+	return
+}
+
+// yamlFields returns indexes of struct fields by their names in YAML.
+func yamlFields(t reflect.Type) map[string][]int {
+	result := map[string][]int{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		tag := field.Tag.Get("yaml")
+
+		if tag == "-" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+
+		if len(parts) > 1 && parts[1] == "inline" {
+			for name, index := range yamlFields(field.Type) {
+				result[name] = append([]int{i}, index...)
+			}
+
+			continue
+		}
+
+		name := parts[0]
+
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		result[name] = []int{i}
+	}
+
+	return result
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func decodeTestYAML(t *testing.T, input string, out interface{}) error {
+	var node yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte(normalizeYAMLString(input)), &node))
+
+	return decodeYAML("a.yml", &node, out)
+}
+
+func TestDecodeYAML(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		var actual importScript
+		err := decodeTestYAML(t, `
+from: foo
+paths: [/foo, /bar]
+to: /usr/bin
+`, &actual)
+
+		require.NoError(t, err)
+		assert.Equal(t, importScript{
+			From: "foo",
+			ImportOptions: ImportOptions{
+				Paths: []string{"/foo", "/bar"},
+				To:    "/usr/bin",
+			},
+		}, actual)
+	})
+
+	t.Run("Positions", func(t *testing.T) {
+		var actual map[string]BuildConfig
+		err := decodeTestYAML(t, `
+foo:
+	from: alpine
+	scripts:
+		- RUN foo
+		- run: bar
+`, &actual)
+
+		require.NoError(t, err)
+
+		build := actual["foo"]
+		assert.Equal(t, Position{File: "a.yml", Line: 1, Column: 1}, build.position(""))
+		assert.Equal(t, Position{File: "a.yml", Line: 2, Column: 9}, build.position("from"))
+		assert.Equal(t, Position{File: "a.yml", Line: 1, Column: 1}, build.position("tags"))
+		assert.Equal(t, Position{File: "a.yml", Line: 4, Column: 7}, build.Scripts[0].pos)
+		assert.Equal(t, Position{File: "a.yml", Line: 5, Column: 7}, build.Scripts[1].pos)
+	})
+
+	t.Run("Collect errors", func(t *testing.T) {
+		var actual map[string]BuildConfig
+		err := decodeTestYAML(t, `
+foo:
+	from: [alpine]
+	bar: baz
+	scripts:
+		- import: {paths: [/foo]}
+		- import: {from: bar, baz: qux}
+		- {}
+bar:
+	from: alpine
+	from: busybox
+	args: foo
+`, &actual)
+
+		assert.EqualError(t, err, `a.yml:2:9: expected a string
+a.yml:3:3: unknown field "bar"
+a.yml:5:7: import must have a source build
+a.yml:6:27: unknown field "baz"
+a.yml:7:7: build script should be a string or a map
+a.yml:10:3: duplicated field "from"
+a.yml:11:9: expected a map`)
+	})
+
+	t.Run("Type error", func(t *testing.T) {
+		var actual struct {
+			Value int `yaml:"value"`
+		}
+
+		err := decodeTestYAML(t, "value: foo", &actual)
+		assert.EqualError(t, err, "a.yml:1:8: cannot unmarshal !!str `foo` into int")
+	})
+}